@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/99designs/keyring"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	"github.com/spf13/cobra"
+)
+
+// accountsCmd represents the accounts command
+var accountsCmd = &cobra.Command{
+	Use:   "accounts",
+	Short: "accounts manages the okta credentials in your keyring",
+}
+
+var accountsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list shows the okta accounts in your keyring",
+	RunE:  accountsListRun,
+}
+
+var accountsShowCmd = &cobra.Command{
+	Use:   "show <account>",
+	Short: "show shows an okta account, without its password",
+	RunE:  accountsShowRun,
+}
+
+var accountsRemoveCmd = &cobra.Command{
+	Use:   "remove <account>",
+	Short: "remove removes an okta account from your keyring",
+	RunE:  accountsRemoveRun,
+}
+
+var accountsRenameCmd = &cobra.Command{
+	Use:   "rename <account> <new-name>",
+	Short: "rename renames an okta account",
+	RunE:  accountsRenameRun,
+}
+
+var accountsSetDefaultCmd = &cobra.Command{
+	Use:   "set-default <account>",
+	Short: "set-default makes profiles without okta_account_name use the account",
+	RunE:  accountsSetDefaultRun,
+}
+
+func init() {
+	RootCmd.AddCommand(accountsCmd)
+	accountsCmd.AddCommand(accountsListCmd, accountsShowCmd, accountsRemoveCmd, accountsRenameCmd, accountsSetDefaultCmd)
+}
+
+// openAccountsKeyring opens the keyring for an accounts subcommand
+func openAccountsKeyring(subcommand string) (keyring.Keyring, error) {
+	if lib.EnvCredentialsSet() {
+		return nil, fmt.Errorf("%s is set, so okta credentials are taken from the environment, not the keyring", lib.EnvOktaUsername)
+	}
+
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+	kr, err := lib.OpenKeyring(allowedBackends)
+	if err != nil {
+		return nil, err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("command", "accounts "+subcommand),
+		})
+	}
+	return kr, nil
+}
+
+func checkArgs(args []string, n int) error {
+	if len(args) < n {
+		return ErrTooFewArguments
+	}
+	if len(args) > n {
+		return ErrTooManyArguments
+	}
+	return nil
+}
+
+func accountsListRun(cmd *cobra.Command, args []string) error {
+	if err := checkArgs(args, 0); err != nil {
+		return err
+	}
+	kr, err := openAccountsKeyring("list")
+	if err != nil {
+		return err
+	}
+
+	accounts, err := lib.ListAccounts(kr)
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		fmt.Fprintln(os.Stderr, "aws-okta: there are no okta accounts in your keyring; add one with `aws-okta add`")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tDOMAIN\tUSERNAME\tMFA\tDEFAULT")
+	for _, a := range accounts {
+		isDefault := ""
+		if a.Default {
+			isDefault = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Name, accountDomain(a.Creds), a.Creds.Username, mfaSummary(a.Creds.MFA), isDefault)
+	}
+	return w.Flush()
+}
+
+func accountsShowRun(cmd *cobra.Command, args []string) error {
+	if err := checkArgs(args, 1); err != nil {
+		return err
+	}
+	kr, err := openAccountsKeyring("show")
+	if err != nil {
+		return err
+	}
+
+	a, err := lib.GetAccount(kr, args[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Account:\t%s\n", a.Name)
+	fmt.Fprintf(w, "Keyring item:\t%s\n", a.Key)
+	fmt.Fprintf(w, "Domain:\t%s\n", accountDomain(a.Creds))
+	fmt.Fprintf(w, "Username:\t%s\n", a.Creds.Username)
+	fmt.Fprintf(w, "MFA provider:\t%s\n", a.Creds.MFA.Provider)
+	fmt.Fprintf(w, "MFA factor type:\t%s\n", a.Creds.MFA.FactorType)
+	fmt.Fprintf(w, "MFA token command:\t%s\n", a.Creds.MFA.TokenCommand)
+	fmt.Fprintf(w, "MFA factors:\t%s\n", strings.Join(a.Creds.MFA.Factors, ", "))
+	fmt.Fprintf(w, "Default:\t%t\n", a.Default)
+
+	profiles, err := listProfiles()
+	if err == nil {
+		fmt.Fprintf(w, "Profiles:\t%s\n", joinOrNone(accountProfiles(kr, profiles, a.Key)))
+	}
+	return w.Flush()
+}
+
+func accountsRemoveRun(cmd *cobra.Command, args []string) error {
+	if err := checkArgs(args, 1); err != nil {
+		return err
+	}
+	kr, err := openAccountsKeyring("remove")
+	if err != nil {
+		return err
+	}
+
+	if err := lib.RemoveAccount(kr, args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "aws-okta: removed okta account %s\n", args[0])
+	return nil
+}
+
+func accountsRenameRun(cmd *cobra.Command, args []string) error {
+	if err := checkArgs(args, 2); err != nil {
+		return err
+	}
+	kr, err := openAccountsKeyring("rename")
+	if err != nil {
+		return err
+	}
+
+	from, to := args[0], args[1]
+	account, err := lib.GetAccount(kr, from)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	if profiles, err := listProfiles(); err == nil {
+		stale = accountProfiles(kr, profiles, account.Key)
+	}
+
+	if err := lib.RenameAccount(kr, from, to); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "aws-okta: renamed okta account %s to %s\n", from, to)
+
+	// profiles naming the account need to follow it; ones using it as the
+	// default keep doing so
+	if !account.Default && len(stale) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: update okta_account_name in these profiles: %s\n", joinOrNone(stale))
+	}
+	return nil
+}
+
+func accountsSetDefaultRun(cmd *cobra.Command, args []string) error {
+	if err := checkArgs(args, 1); err != nil {
+		return err
+	}
+	kr, err := openAccountsKeyring("set-default")
+	if err != nil {
+		return err
+	}
+
+	if err := lib.SetDefaultAccount(kr, args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "aws-okta: profiles without okta_account_name now use okta account %s\n", args[0])
+	return nil
+}
+
+// accountProfiles returns the profiles that use the account in keyring item
+// key, sorted
+func accountProfiles(kr keyring.Keyring, profiles lib.Profiles, key string) []string {
+	var names []string
+	for _, profile := range oktaProfiles(profiles) {
+		if lib.ResolveAccount(kr, profiles.OktaAccount(profile)) == key {
+			names = append(names, profile)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// accountDomain returns the okta domain of creds, including ones added with
+// the deprecated organization
+func accountDomain(creds lib.OktaCreds) string {
+	if creds.Domain == "" && creds.Organization != "" {
+		return fmt.Sprintf("%s.%s", creds.Organization, lib.OktaServerDefault)
+	}
+	return creds.Domain
+}
+
+func mfaSummary(mfa lib.MFAConfig) string {
+	switch {
+	case len(mfa.Factors) > 0:
+		return strings.Join(mfa.Factors, ",")
+	case mfa.Provider == "" && mfa.FactorType == "":
+		return "-"
+	case mfa.FactorType == "":
+		return mfa.Provider
+	case mfa.Provider == "":
+		return mfa.FactorType
+	}
+	return mfa.Provider + " " + mfa.FactorType
+}
+
+func joinOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
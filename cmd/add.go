@@ -2,7 +2,11 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -10,6 +14,7 @@ import (
 	analytics "github.com/segmentio/analytics-go"
 	"github.com/segmentio/aws-okta/lib"
 	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
 )
 
 var (
@@ -17,6 +22,8 @@ var (
 	oktaDomain      string
 	oktaRegion      string
 	oktaAccountName string
+	passwordStdin   bool
+	noValidate      bool
 )
 
 // addCmd represents the add command
@@ -31,9 +38,21 @@ func init() {
 	addCmd.Flags().StringVarP(&oktaDomain, "domain", "", "", "Okta domain (e.g. <orgname>.okta.com)")
 	addCmd.Flags().StringVarP(&username, "username", "", "", "Okta username")
 	addCmd.Flags().StringVarP(&oktaAccountName, "account", "", "", "Okta account name")
+	addCmd.Flags().BoolVarP(&passwordStdin, "password-stdin", "", false, "Read the password from stdin; requires --domain and --username")
+	addCmd.Flags().BoolVarP(&noValidate, "no-validate", "", false, "Store the credentials without checking them with Okta")
 }
 
 func add(cmd *cobra.Command, args []string) error {
+	if lib.EnvCredentialsSet() {
+		return fmt.Errorf("%s is set, so okta credentials are taken from the environment and never stored; unset it to add credentials", lib.EnvOktaUsername)
+	}
+	if oktaAccountName == lib.DefaultAccountName {
+		return fmt.Errorf("%q is the name of the account added without --account", lib.DefaultAccountName)
+	}
+	if passwordStdin && (oktaDomain == "" || username == "") {
+		return errors.New("--password-stdin requires --domain and --username")
+	}
+
 	var allowedBackends []keyring.BackendType
 	if backend != "" {
 		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
@@ -92,19 +111,27 @@ func add(cmd *cobra.Command, args []string) error {
 		}
 	}
 	
-	if oktaAccountName == "" {
-	    oktaAccountName = "okta-creds"
-	} else {
-	    oktaAccountName = "okta-creds-" + oktaAccountName
-	}
+	oktaAccountName = lib.OktaAccountKey(oktaAccountName)
 	log.Debugf("Keyring key: %s", oktaAccountName)
 
-	// Ask for password from prompt
-	password, err := lib.Prompt("Okta password", true)
-	if err != nil {
-		return err
+	var password string
+	if passwordStdin {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+		if password == "" {
+			return errors.New("no password on stdin")
+		}
+	} else {
+		// Ask for password from prompt
+		password, err = lib.Prompt("Okta password", true)
+		if err != nil {
+			return err
+		}
+		fmt.Println()
 	}
-	fmt.Println()
 
 	creds := lib.OktaCreds{
 		Organization: organization,
@@ -118,8 +145,25 @@ func add(cmd *cobra.Command, args []string) error {
 	var dummyProfiles lib.Profiles
 	updateMfaConfig(cmd, dummyProfiles, "", &mfaConfig)
 
-	if err := creds.Validate(mfaConfig); err != nil {
+	// the MFA provider, factor type, token command and factors given now are
+	// used with this account unless a profile or flag says otherwise
+	creds.MFA = lib.MFAConfig{
+		Provider:     mfaConfig.Provider,
+		FactorType:   mfaConfig.FactorType,
+		TokenCommand: mfaConfig.TokenCommand,
+		Factors:      mfaConfig.Factors,
+	}
+
+	if noValidate {
+		log.Debugf("Not validating credentials")
+	} else if err := creds.Validate(mfaConfig); err != nil {
 		log.Debugf("Failed to validate credentials: %s", err)
+		// the credentials are right, but okta won't let the user in yet
+		var lockedOut *lib.LockedOutError
+		var enroll *lib.MFAEnrollRequiredError
+		if xerrors.As(err, &lockedOut) || xerrors.As(err, &enroll) {
+			return err
+		}
 		return ErrFailedToValidateCredentials
 	}
 
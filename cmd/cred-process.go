@@ -10,11 +10,16 @@ import (
 	analytics "github.com/segmentio/analytics-go"
 	"github.com/segmentio/aws-okta/lib"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/xerrors"
 )
 
 const credProcessVersion = 1
 
-var pretty bool
+var (
+	pretty         bool
+	nonInteractive bool
+)
 
 type credProcess struct {
 	Version         int    `json:"Version"`
@@ -24,6 +29,20 @@ type credProcess struct {
 	Expiration      string `json:"Expiration"`
 }
 
+// credProcessError is printed on stderr when cred-process fails in
+// non-interactive mode, for tools to parse
+type credProcessError struct {
+	Version int `json:"Version"`
+	Error   struct {
+		// Code is InteractionRequired when the user has to authenticate
+		// interactively first, and Error otherwise
+		Code    string `json:"Code"`
+		Message string `json:"Message"`
+		Profile string `json:"Profile"`
+		Hint    string `json:"Hint,omitempty"`
+	} `json:"Error"`
+}
+
 // credProcessCmd represents the cred-process command
 var credProcessCmd = &cobra.Command{
 	Use:       "cred-process <profile>",
@@ -38,6 +57,7 @@ func init() {
 	credProcessCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	credProcessCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
 	credProcessCmd.Flags().BoolVarP(&pretty, "pretty", "p", false, "Pretty print display")
+	credProcessCmd.Flags().BoolVarP(&nonInteractive, "non-interactive", "", false, "Fail with a JSON error on stderr instead of prompting (default when there's no terminal)")
 }
 
 func credProcessRun(cmd *cobra.Command, args []string) error {
@@ -46,6 +66,51 @@ func credProcessRun(cmd *cobra.Command, args []string) error {
 	}
 
 	profile := args[0]
+
+	// SDKs run us with stdout and usually stderr captured, so prompts would
+	// never be seen
+	if !cmd.Flags().Lookup("non-interactive").Changed {
+		nonInteractive = !terminal.IsTerminal(int(os.Stdin.Fd())) || !terminal.IsTerminal(int(os.Stderr.Fd()))
+	}
+
+	if nonInteractive {
+		if err := printCredProcess(cmd, profile); err != nil {
+			credProcessFail(profile, err)
+		}
+		return nil
+	}
+	return printCredProcess(cmd, profile)
+}
+
+// credProcessFail prints err as a credProcessError and exits
+func credProcessFail(profile string, err error) {
+	var cpErr credProcessError
+	cpErr.Version = credProcessVersion
+	cpErr.Error.Code = "Error"
+	cpErr.Error.Message = err.Error()
+	cpErr.Error.Profile = profile
+
+	var interactionErr *lib.InteractionRequiredError
+	if xerrors.As(err, &interactionErr) {
+		cpErr.Error.Code = "InteractionRequired"
+		cpErr.Error.Message = interactionErr.Error()
+		cpErr.Error.Hint = fmt.Sprintf("run `aws-okta exec %s -- true` or `aws-okta login %s` in a terminal once, then try again", profile, profile)
+	}
+	var lockedOut *lib.LockedOutError
+	if xerrors.As(err, &lockedOut) {
+		cpErr.Error.Code = "LockedOut"
+	}
+	var enroll *lib.MFAEnrollRequiredError
+	if xerrors.As(err, &enroll) {
+		cpErr.Error.Code = "MFAEnrollRequired"
+	}
+
+	output, _ := json.Marshal(cpErr)
+	fmt.Fprintln(os.Stderr, string(output))
+	os.Exit(1)
+}
+
+func printCredProcess(cmd *cobra.Command, profile string) error {
 	config, err := lib.NewConfigFromEnv()
 	if err != nil {
 		return err
@@ -80,6 +145,7 @@ func credProcessRun(cmd *cobra.Command, args []string) error {
 		Profiles:           profiles,
 		SessionDuration:    sessionTTL,
 		AssumeRoleDuration: assumeRoleTTL,
+		NonInteractive:     nonInteractive,
 	}
 
 	var allowedBackends []keyring.BackendType
@@ -87,7 +153,11 @@ func credProcessRun(cmd *cobra.Command, args []string) error {
 		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
 	}
 
-	kr, err := lib.OpenKeyring(allowedBackends)
+	openKeyring := lib.OpenKeyring
+	if nonInteractive {
+		openKeyring = lib.OpenKeyringNonInteractive
+	}
+	kr, err := openKeyring(allowedBackends)
 	if err != nil {
 		return err
 	}
@@ -122,8 +192,8 @@ func credProcessRun(cmd *cobra.Command, args []string) error {
 		AccessKeyID:     creds.AccessKeyID,
 		SecretAccessKey: creds.SecretAccessKey,
 		SessionToken:    creds.SessionToken,
-		// reuse the provided session duration
-		Expiration: time.Now().Add(p.SessionDuration).Format(time.RFC3339),
+		// the SDK refreshes by running us again once this has passed
+		Expiration: p.GetExpiration().UTC().Format(time.RFC3339),
 	}
 
 	var output []byte
@@ -7,12 +7,16 @@ import (
 	"time"
 
 	"github.com/99designs/keyring"
-	"github.com/alessio/shellescape"
 	analytics "github.com/segmentio/analytics-go"
 	"github.com/segmentio/aws-okta/lib"
 	"github.com/spf13/cobra"
 )
 
+var (
+	envFormat string
+	envUnset  bool
+)
+
 // envCmd represents the env command
 var envCmd = &cobra.Command{
 	Use:       "env <profile>",
@@ -26,9 +30,26 @@ func init() {
 	RootCmd.AddCommand(envCmd)
 	envCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	envCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	envCmd.Flags().StringVarP(&envFormat, "format", "f", "", "Output format: "+strings.Join(envFormatNames, ", ")+" (default from $SHELL)")
+	envCmd.Flags().BoolVarP(&envUnset, "unset", "", false, "Print commands that clear AWS credential, region and profile variables and those set by aws-okta instead")
 }
 
 func envRun(cmd *cobra.Command, args []string) error {
+	format := envFormat
+	if format == "" {
+		format = detectEnvFormat()
+	}
+	if !isEnvFormat(format) {
+		return fmt.Errorf("unknown format %q; use one of %s", format, strings.Join(envFormatNames, ", "))
+	}
+
+	if envUnset {
+		if len(args) > 0 {
+			return ErrTooManyArguments
+		}
+		return writeEnv(os.Stdout, format, awsEnvVars(), true)
+	}
+
 	if len(args) < 1 {
 		return ErrTooFewArguments
 	}
@@ -110,23 +131,33 @@ func envRun(cmd *cobra.Command, args []string) error {
 	}
 	role := strings.Split(roleARN, "/")[1]
 
-	fmt.Printf("export AWS_ACCESS_KEY_ID=%s\n", shellescape.Quote(creds.AccessKeyID))
-	fmt.Printf("export AWS_SECRET_ACCESS_KEY=%s\n", shellescape.Quote(creds.SecretAccessKey))
-	fmt.Printf("export AWS_OKTA_PROFILE=%s\n", shellescape.Quote(profile))
-	fmt.Printf("export AWS_OKTA_ASSUMED_ROLE_ARN=%s\n", shellescape.Quote(roleARN))
-	fmt.Printf("export AWS_OKTA_ASSUMED_ROLE=%s\n", shellescape.Quote(role))
+	vars := []envVar{
+		{Name: "AWS_ACCESS_KEY_ID", Value: creds.AccessKeyID, Secret: true},
+		{Name: "AWS_SECRET_ACCESS_KEY", Value: creds.SecretAccessKey, Secret: true},
+		{Name: "AWS_OKTA_PROFILE", Value: profile},
+		{Name: "AWS_OKTA_ASSUMED_ROLE_ARN", Value: roleARN},
+		{Name: "AWS_OKTA_ASSUMED_ROLE", Value: role},
+	}
 
 	if region, ok := profiles[profile]["region"]; ok {
-		fmt.Printf("export AWS_DEFAULT_REGION=%s\n", shellescape.Quote(region))
-		fmt.Printf("export AWS_REGION=%s\n", shellescape.Quote(region))
+		vars = append(vars,
+			envVar{Name: "AWS_DEFAULT_REGION", Value: region},
+			envVar{Name: "AWS_REGION", Value: region},
+		)
 	}
 
 	if creds.SessionToken != "" {
-		fmt.Printf("export AWS_SESSION_TOKEN=%s\n", shellescape.Quote(creds.SessionToken))
-		fmt.Printf("export AWS_SECURITY_TOKEN=%s\n", shellescape.Quote(creds.SessionToken))
+		vars = append(vars,
+			envVar{Name: "AWS_SESSION_TOKEN", Value: creds.SessionToken, Secret: true},
+			envVar{Name: "AWS_SECURITY_TOKEN", Value: creds.SessionToken, Secret: true},
+		)
 	}
 
-	fmt.Printf("export AWS_OKTA_SESSION_EXPIRATION=%d\n", p.GetExpiration().Unix())
+	vars = append(vars, envVar{Name: "AWS_OKTA_SESSION_EXPIRATION", Value: fmt.Sprintf("%d", p.GetExpiration().Unix())})
+
+	if err := writeEnv(os.Stdout, format, vars, false); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/alessio/shellescape"
+)
+
+// Output formats for env
+const (
+	envFormatSh            = "sh"
+	envFormatFish          = "fish"
+	envFormatPowerShell    = "powershell"
+	envFormatDotenv        = "dotenv"
+	envFormatJSON          = "json"
+	envFormatGitHubActions = "github-actions"
+)
+
+var envFormatNames = []string{
+	envFormatSh, envFormatFish, envFormatPowerShell, envFormatDotenv, envFormatJSON, envFormatGitHubActions,
+}
+
+// envVar is an environment variable to print. Secret values are masked in
+// formats that support it.
+type envVar struct {
+	Name   string
+	Value  string
+	Secret bool
+}
+
+func isEnvFormat(format string) bool {
+	for _, name := range envFormatNames {
+		if name == format {
+			return true
+		}
+	}
+	return false
+}
+
+// detectEnvFormat picks the format for the user's shell
+func detectEnvFormat() string {
+	shell := os.Getenv("SHELL")
+	if shell == "" && runtime.GOOS == "windows" {
+		return envFormatPowerShell
+	}
+	switch strings.TrimSuffix(filepath.Base(shell), ".exe") {
+	case "fish":
+		return envFormatFish
+	case "pwsh", "powershell":
+		return envFormatPowerShell
+	}
+	return envFormatSh
+}
+
+// writeEnv prints vars in format, as commands that set them or, with unset,
+// clear them
+func writeEnv(w io.Writer, format string, vars []envVar, unset bool) error {
+	switch format {
+	case envFormatSh:
+		for _, v := range vars {
+			if unset {
+				fmt.Fprintf(w, "unset %s\n", v.Name)
+			} else {
+				fmt.Fprintf(w, "export %s=%s\n", v.Name, shellescape.Quote(v.Value))
+			}
+		}
+	case envFormatFish:
+		for _, v := range vars {
+			if unset {
+				fmt.Fprintf(w, "set -e %s;\n", v.Name)
+			} else {
+				fmt.Fprintf(w, "set -gx %s %s;\n", v.Name, fishQuote(v.Value))
+			}
+		}
+	case envFormatPowerShell:
+		for _, v := range vars {
+			if unset {
+				fmt.Fprintf(w, "Remove-Item Env:%s -ErrorAction SilentlyContinue\n", v.Name)
+			} else {
+				fmt.Fprintf(w, "$Env:%s = '%s'\n", v.Name, strings.Replace(v.Value, "'", "''", -1))
+			}
+		}
+	case envFormatDotenv:
+		// as read by docker's --env-file, which takes values literally
+		for _, v := range vars {
+			if unset {
+				fmt.Fprintf(w, "%s=\n", v.Name)
+			} else {
+				fmt.Fprintf(w, "%s=%s\n", v.Name, v.Value)
+			}
+		}
+	case envFormatJSON:
+		values := map[string]*string{}
+		for i := range vars {
+			if unset {
+				values[vars[i].Name] = nil
+			} else {
+				values[vars[i].Name] = &vars[i].Value
+			}
+		}
+		out, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(out))
+	case envFormatGitHubActions:
+		return writeGitHubEnv(w, vars, unset)
+	default:
+		return fmt.Errorf("unknown format %q; use one of %s", format, strings.Join(envFormatNames, ", "))
+	}
+	return nil
+}
+
+// writeGitHubEnv masks secret values in the workflow log and appends the
+// variables to the file named by GITHUB_ENV, making them available to later
+// steps of the job
+func writeGitHubEnv(w io.Writer, vars []envVar, unset bool) error {
+	path := os.Getenv("GITHUB_ENV")
+	if path == "" {
+		return fmt.Errorf("GITHUB_ENV is not set; the github-actions format only works in a GitHub Actions step")
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, v := range vars {
+		if unset {
+			// variables can't be removed, only emptied
+			fmt.Fprintf(f, "%s=\n", v.Name)
+			continue
+		}
+		if v.Secret && v.Value != "" {
+			fmt.Fprintf(w, "::add-mask::%s\n", v.Value)
+		}
+		fmt.Fprintf(f, "%s=%s\n", v.Name, v.Value)
+	}
+	return f.Close()
+}
+
+// fishQuote quotes s for fish, which only treats \ and ' specially in single
+// quotes
+func fishQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `'`, `\'`, -1)
+	return "'" + s + "'"
+}
+
+// awsEnvVars returns the AWS credential, region and profile variables, and
+// those set by aws-okta, for unsetting. Settings such as AWS_OKTA_BACKEND are
+// left alone.
+func awsEnvVars() []envVar {
+	names := []string{
+		"AWS_ACCESS_KEY_ID",
+		"AWS_SECRET_ACCESS_KEY",
+		"AWS_SESSION_TOKEN",
+		"AWS_SECURITY_TOKEN",
+		"AWS_CREDENTIAL_FILE",
+		"AWS_SHARED_CREDENTIALS_FILE",
+		"AWS_CONFIG_FILE",
+		"AWS_PROFILE",
+		"AWS_DEFAULT_PROFILE",
+		"AWS_DEFAULT_REGION",
+		"AWS_REGION",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+		"AWS_OKTA_PROFILE",
+		"AWS_OKTA_ASSUMED_ROLE_ARN",
+		"AWS_OKTA_ASSUMED_ROLE",
+		"AWS_OKTA_SESSION_EXPIRATION",
+		envShellSocket,
+		envShellToken,
+		envShellStack,
+	}
+
+	vars := make([]envVar, len(names))
+	for i, name := range names {
+		vars[i].Name = name
+	}
+	return vars
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteEnv(t *testing.T) {
+	vars := []envVar{
+		{Name: "AWS_SESSION_TOKEN", Value: `it's\a "token"`, Secret: true},
+		{Name: "AWS_REGION", Value: "us-east-1"},
+	}
+	cases := []struct {
+		format     string
+		set, unset string
+	}{
+		{
+			envFormatSh,
+			`export AWS_SESSION_TOKEN='it'"'"'s\a "token"'` + "\nexport AWS_REGION=us-east-1\n",
+			"unset AWS_SESSION_TOKEN\nunset AWS_REGION\n",
+		},
+		{
+			envFormatFish,
+			`set -gx AWS_SESSION_TOKEN 'it\'s\\a "token"';` + "\nset -gx AWS_REGION 'us-east-1';\n",
+			"set -e AWS_SESSION_TOKEN;\nset -e AWS_REGION;\n",
+		},
+		{
+			envFormatPowerShell,
+			`$Env:AWS_SESSION_TOKEN = 'it''s\a "token"'` + "\n$Env:AWS_REGION = 'us-east-1'\n",
+			"Remove-Item Env:AWS_SESSION_TOKEN -ErrorAction SilentlyContinue\nRemove-Item Env:AWS_REGION -ErrorAction SilentlyContinue\n",
+		},
+		{
+			envFormatDotenv,
+			`AWS_SESSION_TOKEN=it's\a "token"` + "\nAWS_REGION=us-east-1\n",
+			"AWS_SESSION_TOKEN=\nAWS_REGION=\n",
+		},
+		{
+			envFormatJSON,
+			"{\n  \"AWS_REGION\": \"us-east-1\",\n  \"AWS_SESSION_TOKEN\": \"it's\\\\a \\\"token\\\"\"\n}\n",
+			"{\n  \"AWS_REGION\": null,\n  \"AWS_SESSION_TOKEN\": null\n}\n",
+		},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		if err := writeEnv(&out, c.format, vars, false); err != nil {
+			t.Errorf("%s: %s", c.format, err)
+		} else if out.String() != c.set {
+			t.Errorf("%s: expected\n%s\ngot\n%s", c.format, c.set, out.String())
+		}
+
+		out.Reset()
+		if err := writeEnv(&out, c.format, vars, true); err != nil {
+			t.Errorf("%s --unset: %s", c.format, err)
+		} else if out.String() != c.unset {
+			t.Errorf("%s --unset: expected\n%s\ngot\n%s", c.format, c.unset, out.String())
+		}
+	}
+
+	if err := writeEnv(&bytes.Buffer{}, "csh", vars, false); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
+
+func TestWriteEnvGitHubActions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta-env-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("GITHUB_ENV", os.Getenv("GITHUB_ENV"))
+	path := filepath.Join(dir, "github_env")
+	os.Setenv("GITHUB_ENV", path)
+
+	vars := []envVar{
+		{Name: "AWS_ACCESS_KEY_ID", Value: "AKID", Secret: true},
+		{Name: "AWS_SESSION_TOKEN", Value: "token", Secret: true},
+		{Name: "AWS_REGION", Value: "us-east-1"},
+	}
+	var out bytes.Buffer
+	if err := writeEnv(&out, envFormatGitHubActions, vars, false); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "::add-mask::AKID\n::add-mask::token\n"; out.String() != expected {
+		t.Errorf("expected secrets to be masked with\n%s\ngot\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := writeEnv(&out, envFormatGitHubActions, vars[2:], true); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected nothing to be masked when unsetting, got %q", out.String())
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "AWS_ACCESS_KEY_ID=AKID\nAWS_SESSION_TOKEN=token\nAWS_REGION=us-east-1\nAWS_REGION=\n"; string(content) != expected {
+		t.Errorf("expected GITHUB_ENV to be appended to with\n%s\ngot\n%s", expected, content)
+	}
+
+	os.Unsetenv("GITHUB_ENV")
+	if err := writeEnv(&out, envFormatGitHubActions, vars, false); err == nil {
+		t.Error("expected an error without GITHUB_ENV")
+	}
+}
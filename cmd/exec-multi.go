@@ -0,0 +1,384 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/99designs/keyring"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	multiProfiles    []string
+	multiProfileGlob string
+	multiParallel    int
+	multiJSON        bool
+)
+
+// execMultiCmd represents the exec-multi command
+var execMultiCmd = &cobra.Command{
+	Use:     "exec-multi (--profiles <profile,...> | --profile-glob <pattern>) -- <command>",
+	Short:   "exec-multi runs the command specified once per profile, with that profile's aws credentials set in the environment",
+	RunE:    execMultiRun,
+	PreRun:  execPre,
+	Example: "aws-okta exec-multi --profile-glob 'prod-*' -- aws sts get-caller-identity",
+}
+
+// execMultiResult is the outcome of running the command for one profile
+type execMultiResult struct {
+	Profile  string `json:"profile"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+func init() {
+	RootCmd.AddCommand(execMultiCmd)
+	execMultiCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
+	execMultiCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	execMultiCmd.Flags().StringVarP(&assumeRoleARN, "assume-role-arn", "r", "", "Role arn to assume, overrides arn in profile")
+	execMultiCmd.Flags().StringSliceVarP(&multiProfiles, "profiles", "", nil, "Comma separated profiles to run the command for")
+	execMultiCmd.Flags().StringVarP(&multiProfileGlob, "profile-glob", "", "", "Run the command for all profiles matching this pattern (eg 'prod-*')")
+	execMultiCmd.Flags().IntVarP(&multiParallel, "parallel", "p", 4, "How many commands to run at once")
+	execMultiCmd.Flags().BoolVarP(&multiJSON, "json", "", false, "Print the summary as JSON on stdout; command output all goes to stderr")
+}
+
+func execMultiRun(cmd *cobra.Command, args []string) error {
+	dashIx := cmd.ArgsLenAtDash()
+	if dashIx == -1 {
+		return ErrCommandMissing
+	}
+
+	args, commandPart := args[:dashIx], args[dashIx:]
+	if len(args) > 0 {
+		return ErrTooManyArguments
+	}
+
+	if len(commandPart) == 0 {
+		return ErrCommandMissing
+	}
+
+	if multiParallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+
+	command := commandPart[0]
+	commandArgs := commandPart[1:]
+
+	config, err := lib.NewConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	profiles, err := config.Parse()
+	if err != nil {
+		return err
+	}
+
+	selected, err := selectProfiles(profiles, multiProfiles, multiProfileGlob)
+	if err != nil {
+		return err
+	}
+
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+
+	kr, err := lib.OpenKeyring(allowedBackends)
+	if err != nil {
+		return err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("profile-count", len(selected)).
+				Set("command", "exec-multi"),
+		})
+	}
+
+	// Credentials are retrieved one profile at a time, so that only the first
+	// needs to authenticate with okta; the rest reuse its SAML assertion.
+	assertions := lib.NewSAMLAssertionCache()
+	results := make([]execMultiResult, len(selected))
+	envs := make([]environ, len(selected))
+	for i, profile := range selected {
+		results[i].Profile = profile
+		env, err := profileCommandEnv(cmd, kr, profiles, profile, assertions)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] failed to get credentials: %s\n", profile, err)
+			results[i].ExitCode = -1
+			results[i].Error = err.Error()
+			continue
+		}
+		envs[i] = env
+	}
+
+	runMulti(command, commandArgs, envs, results)
+
+	if multiJSON {
+		out, err := json.MarshalIndent(results, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "PROFILE\tEXIT\tERROR")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", r.Profile, r.ExitCode, r.Error)
+		}
+		w.Flush()
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.ExitCode != 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("command failed for %d of %d profiles", failed, len(results))
+	}
+	return nil
+}
+
+// selectProfiles returns the named profiles and those matching glob, sorted
+func selectProfiles(profiles lib.Profiles, names []string, glob string) ([]string, error) {
+	if len(names) == 0 && glob == "" {
+		return nil, fmt.Errorf("must specify --profiles or --profile-glob")
+	}
+
+	set := map[string]bool{}
+	for _, name := range names {
+		if _, ok := profiles[name]; !ok {
+			return nil, fmt.Errorf("Profile '%s' not found in your aws config. Use list command to see configured profiles", name)
+		}
+		set[name] = true
+	}
+
+	if glob != "" {
+		for name := range profiles {
+			// okta is where defaults live, not a profile
+			if name == "okta" {
+				continue
+			}
+			match, err := path.Match(glob, name)
+			if err != nil {
+				return nil, err
+			}
+			if match {
+				set[name] = true
+			}
+		}
+	}
+
+	if len(set) == 0 {
+		return nil, fmt.Errorf("no profiles match %q", glob)
+	}
+
+	selected := make([]string, 0, len(set))
+	for name := range set {
+		selected = append(selected, name)
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
+
+// profileCommandEnv retrieves credentials for profile and returns the
+// environment to run the command with
+func profileCommandEnv(cmd *cobra.Command, kr keyring.Keyring, profiles lib.Profiles, profile string, assertions *lib.SAMLAssertionCache) (environ, error) {
+	p, err := profileProvider(cmd, kr, profiles, profile, assertions)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := p.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+
+	roleARN, err := p.GetRoleARNWithRegion(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	env := commandEnv(profile, profiles, roleARN)
+	setCredentialsEnv(&env, creds, p.GetExpiration())
+	return env, nil
+}
+
+// profileProvider returns a provider for one of several profiles handled by a
+// command, with the profile's own MFA settings and durations unless they were
+// given as flags. Providers sharing assertions share one okta login.
+func profileProvider(cmd *cobra.Command, kr keyring.Keyring, profiles lib.Profiles, profile string, assertions *lib.SAMLAssertionCache) (*lib.Provider, error) {
+	profileMFAConfig := mfaConfig
+	updateMfaConfig(cmd, profiles, profile, &profileMFAConfig)
+
+	// check profile for both session durations if not explicitly set
+	profileSessionTTL, profileAssumeRoleTTL := sessionTTL, assumeRoleTTL
+	if !cmd.Flags().Lookup("assume-role-ttl").Changed {
+		if err := updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &profileAssumeRoleTTL); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not parse assume_role_ttl from profile %s\n", profile)
+		}
+	}
+
+	if !cmd.Flags().Lookup("session-ttl").Changed {
+		if err := updateDurationFromConfigProfile(profiles, profile, "session_ttl", &profileSessionTTL); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not parse session_ttl from profile %s\n", profile)
+		}
+	}
+
+	opts := lib.ProviderOptions{
+		MFAConfig:              profileMFAConfig,
+		Profiles:               profiles,
+		SessionDuration:        profileSessionTTL,
+		AssumeRoleDuration:     profileAssumeRoleTTL,
+		AssumeRoleArn:          assumeRoleARN,
+		SessionCacheSingleItem: flagSessionCacheSingleItem,
+		SAMLAssertionCache:     assertions,
+	}
+
+	return lib.NewProvider(kr, profile, opts)
+}
+
+// runMulti runs the command for every profile that has an environment, at
+// most multiParallel at a time, and records exit codes in results
+func runMulti(command string, args []string, envs []environ, results []execMultiResult) {
+	var (
+		wg        sync.WaitGroup
+		outMu     sync.Mutex
+		runningMu sync.Mutex
+		running   = map[*child]bool{}
+		sem       = make(chan struct{}, multiParallel)
+	)
+
+	stdout := io.Writer(os.Stdout)
+	if multiJSON {
+		stdout = os.Stderr
+	}
+
+	// Forward signals to all running commands
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, forwardedSignals...)
+	defer signal.Stop(sigChan)
+	go func() {
+		for sig := range sigChan {
+			runningMu.Lock()
+			for c := range running {
+				c.Signal(sig)
+			}
+			runningMu.Unlock()
+		}
+	}()
+
+	for i := range results {
+		if envs[i] == nil {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			r := &results[i]
+			prefix := fmt.Sprintf("[%s] ", r.Profile)
+			outW := &prefixWriter{mu: &outMu, out: stdout, prefix: prefix}
+			errW := &prefixWriter{mu: &outMu, out: os.Stderr, prefix: prefix}
+
+			ecmd := exec.Command(command, args...)
+			ecmd.Stdout = outW
+			ecmd.Stderr = errW
+			ecmd.Env = envs[i]
+
+			c, err := startChild(ecmd)
+			if err != nil {
+				r.ExitCode = -1
+				r.Error = err.Error()
+				return
+			}
+
+			runningMu.Lock()
+			running[c] = true
+			runningMu.Unlock()
+
+			ws, err := c.Wait()
+
+			runningMu.Lock()
+			delete(running, c)
+			runningMu.Unlock()
+
+			outW.Flush()
+			errW.Flush()
+
+			switch {
+			case err != nil:
+				r.ExitCode = -1
+				r.Error = err.Error()
+			case ws.Signaled():
+				r.ExitCode = 128 + int(ws.Signal())
+				r.Error = fmt.Sprintf("killed by %s", ws.Signal())
+			default:
+				r.ExitCode = ws.ExitStatus()
+			}
+			log.Debugf("%s exited with %d", r.Profile, r.ExitCode)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// prefixWriter writes complete lines to out, each preceded by prefix. Writers
+// sharing mu don't interleave their lines.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes out any trailing partial line
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/aws-okta/lib"
+)
+
+func TestSelectProfiles(t *testing.T) {
+	profiles := lib.Profiles{
+		"okta":        {"aws_saml_url": "home/amazon_aws/xyz/123"},
+		"dev":         {},
+		"dev-admin":   {},
+		"prod":        {},
+		"prod-admin":  {},
+		"staging-ops": {},
+	}
+	cases := []struct {
+		names    []string
+		glob     string
+		selected []string
+	}{
+		{[]string{"prod", "dev"}, "", []string{"dev", "prod"}},
+		{nil, "*-admin", []string{"dev-admin", "prod-admin"}},
+		{[]string{"prod-admin"}, "*-admin", []string{"dev-admin", "prod-admin"}},
+		{[]string{"staging-ops"}, "dev*", []string{"dev", "dev-admin", "staging-ops"}},
+		{nil, "*", []string{"dev", "dev-admin", "prod", "prod-admin", "staging-ops"}},
+		{nil, "?rod", []string{"prod"}},
+	}
+	for _, c := range cases {
+		selected, err := selectProfiles(profiles, c.names, c.glob)
+		if err != nil {
+			t.Errorf("%v %q: unexpected error %s", c.names, c.glob, err)
+			continue
+		}
+		if !reflect.DeepEqual(selected, c.selected) {
+			t.Errorf("%v %q: expected %v, got %v", c.names, c.glob, c.selected, selected)
+		}
+	}
+
+	failures := []struct {
+		names []string
+		glob  string
+		err   string
+	}{
+		{nil, "", "must specify"},
+		{[]string{"dev", "qa"}, "", "'qa' not found"},
+		{[]string{"qa"}, "*-admin", "'qa' not found"},
+		{nil, "qa-*", "no profiles match"},
+		{nil, "okta", "no profiles match"},
+		{nil, "[", "syntax error"},
+	}
+	for _, c := range failures {
+		if _, err := selectProfiles(profiles, c.names, c.glob); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%v %q: expected an error containing %q, got %v", c.names, c.glob, c.err, err)
+		}
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var mu sync.Mutex
+	var out bytes.Buffer
+	w := &prefixWriter{mu: &mu, out: &out, prefix: "[dev] "}
+	for _, chunk := range []string{"par", "tial\nli", "ne two\n", "\n", "no newline"} {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("writing %q: wrote %d, %v", chunk, n, err)
+		}
+	}
+	if expected := "[dev] partial\n[dev] line two\n[dev] \n"; out.String() != expected {
+		t.Errorf("expected only whole lines before flushing:\n%s\ngot\n%s", expected, out.String())
+	}
+	w.Flush()
+	w.Flush()
+	if expected := "[dev] partial\n[dev] line two\n[dev] \n[dev] no newline\n"; out.String() != expected {
+		t.Errorf("expected the partial line after flushing:\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestPrefixWriterConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	var out bytes.Buffer
+	const lines = 200
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"dev", "prod"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			w := &prefixWriter{mu: &mu, out: &out, prefix: "[" + name + "] "}
+			var data bytes.Buffer
+			for i := 0; i < lines; i++ {
+				fmt.Fprintf(&data, "%s line %d\n", name, i)
+			}
+			// write in chunks that straddle the line breaks
+			b := data.Bytes()
+			for size := 1; len(b) > 0; size = size%7 + 1 {
+				if size > len(b) {
+					size = len(b)
+				}
+				w.Write(b[:size])
+				b = b[size:]
+			}
+			w.Flush()
+		}(name)
+	}
+	wg.Wait()
+
+	next := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		var prefix, name string
+		var i int
+		if _, err := fmt.Sscanf(line, "%s %s line %d", &prefix, &name, &i); err != nil || prefix != "["+name+"]" {
+			t.Fatalf("mixed up line %q", line)
+		}
+		if i != next[name] {
+			t.Fatalf("expected line %d of %s, got %q", next[name], name, line)
+		}
+		next[name]++
+	}
+	if next["dev"] != lines || next["prod"] != lines {
+		t.Errorf("expected %d lines of each, got %v", lines, next)
+	}
+}
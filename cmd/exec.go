@@ -13,8 +13,10 @@ import (
 	"time"
 
 	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	analytics "github.com/segmentio/analytics-go"
 	"github.com/segmentio/aws-okta/lib"
+	"github.com/segmentio/aws-okta/lib/server"
 	"github.com/spf13/cobra"
 )
 
@@ -22,6 +24,11 @@ var (
 	sessionTTL    time.Duration
 	assumeRoleTTL time.Duration
 	assumeRoleARN string
+	execServer    bool
+	execReplace   bool
+	execRefresh   string
+	expiryWindow  time.Duration
+	execCredsFile bool
 )
 
 func mustListProfiles() lib.Profiles {
@@ -46,6 +53,11 @@ func init() {
 	execCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	execCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
 	execCmd.Flags().StringVarP(&assumeRoleARN, "assume-role-arn", "r", "", "Role arn to assume, overrides arn in profile")
+	execCmd.Flags().BoolVarP(&execServer, "server", "", false, "Serve refreshing credentials to the command over a local container credentials endpoint instead of setting static keys")
+	execCmd.Flags().BoolVarP(&execReplace, "exec-replace", "", false, "Replace aws-okta with the command instead of running it as a child process")
+	execCmd.Flags().StringVarP(&execRefresh, "refresh", "", "", "What to do as credentials approach expiry: warn, restart the command, or refresh a credentials file and signal the command (eg signal:SIGHUP)")
+	execCmd.Flags().DurationVarP(&expiryWindow, "expiry-window", "", lib.DefaultExpiryWindow, "How long before expiry credentials are refreshed")
+	execCmd.Flags().BoolVarP(&execCredsFile, "credentials-file", "", false, "Give the command a private, refreshed shared credentials file instead of setting keys in its environment")
 }
 
 func loadDurationFlagFromEnv(cmd *cobra.Command, flagName string, envVar string, val *time.Duration) error {
@@ -126,6 +138,18 @@ func execRun(cmd *cobra.Command, args []string) error {
 		return ErrCommandMissing
 	}
 
+	refresh, err := parseRefreshPolicy(execRefresh)
+	if err != nil {
+		return err
+	}
+
+	if execReplace && (execServer || refresh.Mode != "" || execCredsFile) {
+		return fmt.Errorf("--exec-replace can't be combined with --server, --refresh or --credentials-file, which need aws-okta to keep running")
+	}
+	if execServer && (refresh.Mode != "" || execCredsFile) {
+		return fmt.Errorf("--refresh and --credentials-file can't be combined with --server, which refreshes credentials by itself")
+	}
+
 	profile := args[0]
 	command := commandPart[0]
 
@@ -169,6 +193,7 @@ func execRun(cmd *cobra.Command, args []string) error {
 		SessionDuration:    sessionTTL,
 		AssumeRoleDuration: assumeRoleTTL,
 		AssumeRoleArn:      assumeRoleARN,
+		ExpiryWindow:       expiryWindow,
 	}
 
 	var allowedBackends []keyring.BackendType
@@ -209,8 +234,80 @@ func execRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	role := strings.Split(roleARN, "/")[1]
 
+	env := commandEnv(profile, profiles, roleARN)
+
+	// Listen for signals before anything needs cleaning up, so that none can
+	// kill us and leave credentials behind. They're forwarded to the command
+	// once it is running.
+	sigChan := make(chan os.Signal, 1)
+	if !execReplace {
+		signal.Notify(sigChan, forwardedSignals...)
+		defer signal.Stop(sigChan)
+	}
+
+	// the command owns the terminal from here on, so refreshing can't
+	// prompt; if okta needs the user again, refreshes fail and are retried
+	p.NonInteractive = true
+
+	var credsFile *credentialsFile
+	switch {
+	case execServer:
+		// the child only learns where to fetch credentials from; the server
+		// retrieves fresh ones from the session cache or okta as they expire
+		srv, err := server.NewECSServer(p)
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+		srv.Seed(creds)
+
+		go func() {
+			if err := srv.Serve(); err != nil {
+				log.Errorf("Credentials server failed: %s", err)
+			}
+		}()
+
+		env.Set("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL())
+		env.Set("AWS_CONTAINER_AUTHORIZATION_TOKEN", srv.AuthToken())
+	case execCredsFile, refresh.Mode == refreshSignal:
+		// the child reads its credentials from disk, so they stay out of
+		// its environment and can be refreshed in place
+		credsFile, err = newCredentialsFile(profile, profiles[profile]["region"])
+		if err != nil {
+			return err
+		}
+		defer credsFile.Remove()
+
+		if err := credsFile.Write(creds, p.GetExpiration()); err != nil {
+			return err
+		}
+		credsFile.Env(&env)
+	default:
+		setCredentialsEnv(&env, creds, p.GetExpiration())
+	}
+
+	if execReplace {
+		return replaceProcess(command, commandArgs, env)
+	}
+
+	waitStatus, err := superviseCommand(command, commandArgs, env, sigChan, p, profile, refresh, credsFile)
+	if err != nil {
+		return err
+	}
+	if waitStatus.Signaled() || waitStatus.ExitStatus() != 0 {
+		// exiting skips deferred cleanup
+		if credsFile != nil {
+			credsFile.Remove()
+		}
+		exitWithStatus(waitStatus)
+	}
+	return nil
+}
+
+// commandEnv returns our environment for running a command as profile,
+// without any AWS credentials
+func commandEnv(profile string, profiles lib.Profiles, roleARN string) environ {
 	env := environ(os.Environ())
 	env.Unset("AWS_ACCESS_KEY_ID")
 	env.Unset("AWS_SECRET_ACCESS_KEY")
@@ -218,53 +315,139 @@ func execRun(cmd *cobra.Command, args []string) error {
 	env.Unset("AWS_DEFAULT_PROFILE")
 	env.Unset("AWS_PROFILE")
 	env.Unset("AWS_OKTA_PROFILE")
+	env.Unset("AWS_SESSION_TOKEN")
+	env.Unset("AWS_SECURITY_TOKEN")
+	env.Unset("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+	env.Unset("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	env.Unset("AWS_CONTAINER_AUTHORIZATION_TOKEN")
 
 	if region, ok := profiles[profile]["region"]; ok {
 		env.Set("AWS_DEFAULT_REGION", region)
 		env.Set("AWS_REGION", region)
 	}
 
-	env.Set("AWS_ACCESS_KEY_ID", creds.AccessKeyID)
-	env.Set("AWS_SECRET_ACCESS_KEY", creds.SecretAccessKey)
 	env.Set("AWS_OKTA_PROFILE", profile)
 	env.Set("AWS_OKTA_ASSUMED_ROLE_ARN", roleARN)
-	env.Set("AWS_OKTA_ASSUMED_ROLE", role)
+	env.Set("AWS_OKTA_ASSUMED_ROLE", strings.Split(roleARN, "/")[1])
+
+	return env
+}
+
+// setCredentialsEnv sets static credentials in env
+func setCredentialsEnv(env *environ, creds credentials.Value, expiration time.Time) {
+	env.Set("AWS_ACCESS_KEY_ID", creds.AccessKeyID)
+	env.Set("AWS_SECRET_ACCESS_KEY", creds.SecretAccessKey)
 
 	if creds.SessionToken != "" {
 		env.Set("AWS_SESSION_TOKEN", creds.SessionToken)
 		env.Set("AWS_SECURITY_TOKEN", creds.SessionToken)
+	} else {
+		env.Unset("AWS_SESSION_TOKEN")
+		env.Unset("AWS_SECURITY_TOKEN")
 	}
 
-	env.Set("AWS_OKTA_SESSION_EXPIRATION", fmt.Sprintf("%d", p.GetExpiration().Unix()))
+	env.Set("AWS_OKTA_SESSION_EXPIRATION", fmt.Sprintf("%d", expiration.Unix()))
+}
 
-	ecmd := exec.Command(command, commandArgs...)
-	ecmd.Stdin = os.Stdin
-	ecmd.Stdout = os.Stdout
-	ecmd.Stderr = os.Stderr
-	ecmd.Env = env
+// superviseCommand runs the command until it exits, forwarding signals from
+// sigChan to it and acting on credential expiry according to policy. When
+// credsFile is set, it is kept refreshed. With the restart policy, the command
+// may be run several times.
+func superviseCommand(command string, args []string, env environ, sigChan <-chan os.Signal, p *lib.Provider, profile string, policy refreshPolicy, credsFile *credentialsFile) (syscall.WaitStatus, error) {
+	type result struct {
+		waitStatus syscall.WaitStatus
+		err        error
+	}
 
-	// Forward SIGINT, SIGTERM, SIGKILL to the child command
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt, os.Kill)
+	for {
+		ecmd := exec.Command(command, args...)
+		ecmd.Stdin = os.Stdin
+		ecmd.Stdout = os.Stdout
+		ecmd.Stderr = os.Stderr
+		ecmd.Env = env
 
-	go func() {
-		sig := <-sigChan
-		if ecmd.Process != nil {
-			ecmd.Process.Signal(sig)
+		c, err := startChild(ecmd)
+		if err != nil {
+			var ws syscall.WaitStatus
+			return ws, err
 		}
-	}()
 
-	var waitStatus syscall.WaitStatus
-	if err := ecmd.Run(); err != nil {
-		if err != nil {
-			return err
+		done := make(chan result, 1)
+		go func() {
+			ws, err := c.Wait()
+			done <- result{ws, err}
+		}()
+
+		expiration := p.GetExpiration()
+		var warnC, refreshC, killC <-chan time.Time
+		switch policy.Mode {
+		case refreshWarn:
+			if at, ok := nextExpiryWarning(expiration, time.Now()); ok {
+				warnC = time.After(time.Until(at))
+			}
 		}
-		if exitError, ok := err.(*exec.ExitError); ok {
-			waitStatus = exitError.Sys().(syscall.WaitStatus)
-			os.Exit(waitStatus.ExitStatus())
+		if credsFile != nil || policy.Mode == refreshSignal || policy.Mode == refreshRestart {
+			refreshC = time.After(refreshDelay(expiration, p.ExpiryWindow))
+		}
+
+		restarting := false
+	wait:
+		for {
+			select {
+			case sig := <-sigChan:
+				if err := c.Signal(sig); err != nil {
+					log.Debugf("failed forwarding %s: %s", sig, err)
+				}
+			case <-warnC:
+				warnExpiry(profile, expiration)
+				warnC = nil
+				if at, ok := nextExpiryWarning(expiration, time.Now()); ok {
+					warnC = time.After(time.Until(at))
+				}
+			case <-refreshC:
+				creds, err := p.Retrieve()
+				if err != nil {
+					warnRefreshFailed(profile, err)
+					warnExpiry(profile, expiration)
+					refreshC = time.After(refreshRetryInterval)
+					continue
+				}
+				expiration = p.GetExpiration()
+				refreshC = time.After(refreshDelay(expiration, p.ExpiryWindow))
+				log.Debugf("refreshed credentials for %s, now expiring at %s", profile, expiration)
+
+				if credsFile != nil {
+					if err := credsFile.Write(creds, expiration); err != nil {
+						fmt.Fprintf(os.Stderr, "aws-okta: failed to write refreshed credentials: %s\n", err)
+						continue
+					}
+				}
+
+				switch policy.Mode {
+				case refreshSignal:
+					if err := c.Signal(policy.Signal); err != nil {
+						log.Debugf("failed sending %s: %s", policy.Signal, err)
+					}
+				case refreshRestart:
+					fmt.Fprintf(os.Stderr, "aws-okta: restarting %s with refreshed credentials\n", command)
+					if credsFile == nil {
+						setCredentialsEnv(&env, creds, expiration)
+					}
+					restarting = true
+					refreshC = nil
+					c.Stop()
+					killC = time.After(restartGracePeriod)
+				}
+			case <-killC:
+				c.Kill()
+			case res := <-done:
+				if res.err != nil || !restarting {
+					return res.waitStatus, res.err
+				}
+				break wait
+			}
 		}
 	}
-	return nil
 }
 
 // environ is a slice of strings representing the environment, in the form "key=value".
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/segmentio/aws-okta/lib"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// What exec does as credentials approach expiry
+const (
+	// print warnings on stderr
+	refreshWarn = "warn"
+	// refresh the credentials file and signal the command
+	refreshSignal = "signal"
+	// restart the command with fresh credentials in its environment
+	refreshRestart = "restart"
+)
+
+// how often a failed refresh is retried
+const refreshRetryInterval = time.Minute
+
+// how long a command gets to exit before being killed on restart
+const restartGracePeriod = 10 * time.Second
+
+// how long before expiry to warn
+var expiryWarnings = []time.Duration{15 * time.Minute, 5 * time.Minute, time.Minute, 0}
+
+type refreshPolicy struct {
+	Mode   string
+	Signal os.Signal
+}
+
+// parseRefreshPolicy parses the value of --refresh: warn, restart or
+// signal:<name>, eg signal:SIGHUP
+func parseRefreshPolicy(s string) (refreshPolicy, error) {
+	switch {
+	case s == "":
+		return refreshPolicy{}, nil
+	case s == refreshWarn, s == refreshRestart:
+		return refreshPolicy{Mode: s}, nil
+	case strings.HasPrefix(s, refreshSignal+":"):
+		name := strings.TrimPrefix(s, refreshSignal+":")
+		sig, ok := signalByName(name)
+		if !ok {
+			return refreshPolicy{}, fmt.Errorf("unsupported refresh signal %q", name)
+		}
+		return refreshPolicy{Mode: refreshSignal, Signal: sig}, nil
+	}
+	return refreshPolicy{}, fmt.Errorf("invalid refresh policy %q; use warn, restart or signal:<SIGNAL>", s)
+}
+
+// nextExpiryWarning returns when to next warn about credentials expiring at
+// expiration, or false if there is nothing left to warn about
+func nextExpiryWarning(expiration, now time.Time) (time.Time, bool) {
+	for _, before := range expiryWarnings {
+		if at := expiration.Add(-before); at.After(now) {
+			return at, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// refreshDelay returns how long to wait before refreshing credentials that
+// expire at expiration. It's at least refreshRetryInterval, so credentials
+// issued with less than window left aren't refreshed in a busy loop.
+func refreshDelay(expiration time.Time, window time.Duration) time.Duration {
+	if d := time.Until(expiration.Add(-window)); d > refreshRetryInterval {
+		return d
+	}
+	return refreshRetryInterval
+}
+
+// warnRefreshFailed tells the user that credentials for profile couldn't be
+// refreshed, and what to do if logging in again is needed
+func warnRefreshFailed(profile string, err error) {
+	var interactionErr *lib.InteractionRequiredError
+	if xerrors.As(err, &interactionErr) {
+		fmt.Fprintf(os.Stderr, "aws-okta: refreshing credentials for %s needs you to log in again (%s); run `aws-okta login %s` in another terminal\n", profile, interactionErr.Reason, profile)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "aws-okta: failed to refresh credentials for %s: %s\n", profile, err)
+}
+
+func warnExpiry(profile string, expiration time.Time) {
+	remaining := time.Until(expiration).Round(time.Second)
+	if remaining <= 0 {
+		fmt.Fprintf(os.Stderr, "aws-okta: credentials for %s have expired\n", profile)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "aws-okta: credentials for %s expire in %s\n", profile, remaining)
+}
+
+// credentialsFile is a private shared credentials and config file pair holding
+// a single profile, for commands that read their credentials from disk
+type credentialsFile struct {
+	dir     string
+	profile string
+}
+
+// newCredentialsFile creates the file pair in a new private directory. The
+// user's runtime directory is preferred, as it usually isn't backed by disk.
+func newCredentialsFile(profile string, region string) (*credentialsFile, error) {
+	dir, err := ioutil.TempDir(os.Getenv("XDG_RUNTIME_DIR"), "aws-okta-")
+	if err != nil {
+		return nil, err
+	}
+	f := &credentialsFile{dir: dir, profile: profile}
+
+	section := "profile " + profile
+	if profile == "default" {
+		section = profile
+	}
+	config := fmt.Sprintf("[%s]\n", section)
+	if region != "" {
+		config += fmt.Sprintf("region = %s\n", region)
+	}
+	if err := f.replace(f.ConfigPath(), []byte(config)); err != nil {
+		f.Remove()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *credentialsFile) CredentialsPath() string {
+	return filepath.Join(f.dir, "credentials")
+}
+
+func (f *credentialsFile) ConfigPath() string {
+	return filepath.Join(f.dir, "config")
+}
+
+// Write replaces the credentials. Readers see either the old or the new file,
+// never a partial one.
+func (f *credentialsFile) Write(creds credentials.Value, expiration time.Time) error {
+	content := fmt.Sprintf("[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\n",
+		f.profile, creds.AccessKeyID, creds.SecretAccessKey)
+	if creds.SessionToken != "" {
+		content += fmt.Sprintf("aws_session_token = %s\naws_security_token = %s\n",
+			creds.SessionToken, creds.SessionToken)
+	}
+	content += fmt.Sprintf("x_aws_okta_expiration = %s\n", expiration.UTC().Format(time.RFC3339))
+
+	return f.replace(f.CredentialsPath(), []byte(content))
+}
+
+// replace atomically replaces path with a new 0600 file holding content
+func (f *credentialsFile) replace(path string, content []byte) error {
+	tmp, err := ioutil.TempFile(f.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Env points the AWS SDKs at the file pair
+func (f *credentialsFile) Env(env *environ) {
+	env.Set("AWS_SHARED_CREDENTIALS_FILE", f.CredentialsPath())
+	env.Set("AWS_CONFIG_FILE", f.ConfigPath())
+	env.Set("AWS_PROFILE", f.profile)
+}
+
+// Remove overwrites the credentials and deletes the file pair and its
+// directory. It is safe to call more than once.
+func (f *credentialsFile) Remove() error {
+	if creds, err := os.OpenFile(f.CredentialsPath(), os.O_WRONLY, 0); err == nil {
+		if err := wipe(creds); err != nil {
+			log.Debugf("failed to wipe %s: %s", f.CredentialsPath(), err)
+		}
+		creds.Close()
+	}
+	return os.RemoveAll(f.dir)
+}
+
+// wipe overwrites the file's contents with zeros and flushes them to disk
+func wipe(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(make([]byte, info.Size()), 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestParseRefreshPolicy(t *testing.T) {
+	cases := []struct {
+		value string
+		mode  string
+		valid bool
+	}{
+		{"", "", true},
+		{"warn", refreshWarn, true},
+		{"restart", refreshRestart, true},
+		{"signal:SIGHUP", refreshSignal, runtime.GOOS != "windows"},
+		{"signal:usr1", refreshSignal, runtime.GOOS != "windows"},
+		{"signal:", "", false},
+		{"signal:SIGBOGUS", "", false},
+		{"signal", "", false},
+		{"Warn", "", false},
+		{"reload", "", false},
+	}
+	for _, c := range cases {
+		policy, err := parseRefreshPolicy(c.value)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", c.value, policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", c.value, err)
+			continue
+		}
+		if policy.Mode != c.mode {
+			t.Errorf("%q: expected mode %q, got %q", c.value, c.mode, policy.Mode)
+		}
+		if (policy.Mode == refreshSignal) != (policy.Signal != nil) {
+			t.Errorf("%q: expected a signal only for signal mode, got %v", c.value, policy.Signal)
+		}
+	}
+}
+
+func TestNextExpiryWarning(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		expiresIn time.Duration
+		warnIn    time.Duration
+		ok        bool
+	}{
+		{time.Hour, 45 * time.Minute, true},
+		{15 * time.Minute, 10 * time.Minute, true},
+		{10 * time.Minute, 5 * time.Minute, true},
+		{2 * time.Minute, time.Minute, true},
+		{30 * time.Second, 30 * time.Second, true},
+		{0, 0, false},
+		{-time.Minute, 0, false},
+	}
+	for _, c := range cases {
+		at, ok := nextExpiryWarning(now.Add(c.expiresIn), now)
+		if ok != c.ok {
+			t.Errorf("expiring in %s: expected %v, got %v", c.expiresIn, c.ok, ok)
+			continue
+		}
+		if ok && !at.Equal(now.Add(c.warnIn)) {
+			t.Errorf("expiring in %s: expected a warning in %s, got %s", c.expiresIn, c.warnIn, at.Sub(now))
+		}
+	}
+}
+
+func TestRefreshDelay(t *testing.T) {
+	window := 5 * time.Minute
+	cases := []struct {
+		expiresIn time.Duration
+		delay     time.Duration
+	}{
+		{time.Hour, 55 * time.Minute},
+		{10 * time.Minute, 5 * time.Minute},
+		// inside the window, or already expired, retry after the floor
+		{window + 30*time.Second, refreshRetryInterval},
+		{window, refreshRetryInterval},
+		{time.Minute, refreshRetryInterval},
+		{-time.Hour, refreshRetryInterval},
+	}
+	for _, c := range cases {
+		delay := refreshDelay(time.Now().Add(c.expiresIn), window)
+		if diff := c.delay - delay; diff < 0 || diff > time.Second {
+			t.Errorf("expiring in %s: expected a delay of %s, got %s", c.expiresIn, c.delay, delay)
+		}
+	}
+}
+
+func TestCredentialsFile(t *testing.T) {
+	runtimeDir, err := ioutil.TempDir("", "aws-okta-runtime-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runtimeDir)
+	defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+	os.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	f, err := newCredentialsFile("dev", "us-west-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Remove()
+
+	if filepath.Dir(f.dir) != runtimeDir {
+		t.Errorf("expected the files in XDG_RUNTIME_DIR, got %s", f.dir)
+	}
+	config, err := ioutil.ReadFile(f.ConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config) != "[profile dev]\nregion = us-west-2\n" {
+		t.Errorf("unexpected config %q", config)
+	}
+
+	expiration := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := f.Write(credentials.Value{AccessKeyID: "AKID1", SecretAccessKey: "secret1", SessionToken: "token1"}, expiration); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		for _, path := range []string{f.dir, f.CredentialsPath(), f.ConfigPath()} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm&0077 != 0 {
+				t.Errorf("expected %s to be private, got mode %s", path, info.Mode())
+			}
+		}
+	}
+
+	// a reader holding the old file keeps seeing it whole
+	old, err := os.Open(f.CredentialsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	if err := f.Write(credentials.Value{AccessKeyID: "AKID2", SecretAccessKey: "secret2"}, expiration); err != nil {
+		t.Fatal(err)
+	}
+	oldContent, err := ioutil.ReadAll(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(oldContent), "AKID1") || !strings.Contains(string(oldContent), "aws_session_token = token1") {
+		t.Errorf("expected the old file to be replaced, not rewritten, got %q", oldContent)
+	}
+	content, err := ioutil.ReadFile(f.CredentialsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[dev]\naws_access_key_id = AKID2\naws_secret_access_key = secret2\nx_aws_okta_expiration = 2030-01-01T00:00:00Z\n"
+	if string(content) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, content)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(f.dir, ".tmp-*")); len(tmps) != 0 {
+		t.Errorf("expected no temporary files to be left, got %v", tmps)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// keep a link to the credentials to see what Remove leaves on disk
+	link := filepath.Join(runtimeDir, "link")
+	if err := os.Link(f.CredentialsPath(), link); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(f.dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", f.dir, err)
+	}
+	wiped, err := ioutil.ReadFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wiped) != len(content) || !bytes.Equal(wiped, make([]byte, len(content))) {
+		t.Errorf("expected the credentials to be overwritten with zeros, got %q", wiped)
+	}
+	if err := f.Remove(); err != nil {
+		t.Errorf("expected removing twice to be fine, got %s", err)
+	}
+}
@@ -0,0 +1,185 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// signals forwarded to the command's process group
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// child is a command running in its own process group
+type child struct {
+	cmd *exec.Cmd
+	// fd of the terminal handed over to the child, or -1
+	tty int
+}
+
+// startChild starts cmd as the leader of a new process group. If cmd uses our
+// stdin, stdout and stderr and we own the terminal, the new group is made the
+// foreground group so that the command can read from it and receive ^C/^Z
+// directly.
+func startChild(cmd *exec.Cmd) (*child, error) {
+	c := &child{cmd: cmd, tty: -1}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// Wait reaps a child on the terminal itself, to see it stop, which is
+	// only safe when os/exec isn't copying its output
+	_, stdoutFile := cmd.Stdout.(*os.File)
+	_, stderrFile := cmd.Stderr.(*os.File)
+	if fd := int(os.Stdin.Fd()); cmd.Stdin == os.Stdin && stdoutFile && stderrFile && isForeground(fd) {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = fd
+		c.tty = fd
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Signal sends sig to the whole process group of the command
+func (c *child) Signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return c.cmd.Process.Signal(sig)
+	}
+	return syscall.Kill(-c.cmd.Process.Pid, s)
+}
+
+// Stop asks the command to exit
+func (c *child) Stop() error {
+	return c.Signal(syscall.SIGTERM)
+}
+
+// Kill forcibly stops the command and everything in its process group
+func (c *child) Kill() error {
+	return c.Signal(syscall.SIGKILL)
+}
+
+// Wait waits for the command to exit and returns its status, once all of its
+// output has been copied.
+//
+// If the command is on the terminal and is suspended from it, we suspend
+// ourselves as well so that the shell regains control, and resume the
+// command when we are continued. Seeing it stop means waiting for it
+// ourselves, which reaps it when it exits; that's only done for commands
+// using our own stdio files, as os/exec has no output to copy for them.
+func (c *child) Wait() (syscall.WaitStatus, error) {
+	if c.tty < 0 {
+		// cmd.Wait reaps the command, then waits for its output to be
+		// copied
+		err := c.cmd.Wait()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.Sys().(syscall.WaitStatus), nil
+		}
+		if err != nil {
+			var ws syscall.WaitStatus
+			return ws, err
+		}
+		return c.cmd.ProcessState.Sys().(syscall.WaitStatus), nil
+	}
+
+	pid := c.cmd.Process.Pid
+	for {
+		var ws syscall.WaitStatus
+		_, err := syscall.Wait4(pid, &ws, syscall.WUNTRACED, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return ws, err
+		}
+
+		c.reclaimTerminal()
+		if !ws.Stopped() {
+			// the process is reaped, so it mustn't be waited for again,
+			// where its pid may have been reused
+			c.cmd.Process.Release()
+			return ws, nil
+		}
+
+		syscall.Kill(syscall.Getpid(), ws.StopSignal())
+
+		tcsetpgrp(c.tty, pid)
+		syscall.Kill(-pid, syscall.SIGCONT)
+	}
+}
+
+// reclaimTerminal makes our process group the foreground group again
+func (c *child) reclaimTerminal() {
+	if c.tty < 0 {
+		return
+	}
+	// we are a background process at this point, so changing the foreground
+	// group would otherwise stop us with SIGTTOU
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	tcsetpgrp(c.tty, syscall.Getpgrp())
+}
+
+// exitWithStatus exits the same way the command did: with its exit code, or
+// by being killed with the same signal
+func exitWithStatus(ws syscall.WaitStatus) {
+	if ws.Signaled() {
+		sig := ws.Signal()
+		signal.Reset(sig)
+		syscall.Kill(syscall.Getpid(), sig)
+		// the signal didn't kill us; fall back to the shell convention
+		os.Exit(128 + int(sig))
+	}
+	os.Exit(ws.ExitStatus())
+}
+
+// replaceProcess replaces aws-okta with the command
+func replaceProcess(command string, args []string, env []string) error {
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, append([]string{command}, args...), env)
+}
+
+func isForeground(fd int) bool {
+	pgrp, err := tcgetpgrp(fd)
+	return err == nil && pgrp == syscall.Getpgrp()
+}
+
+func tcgetpgrp(fd int) (int, error) {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&pgrp)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgrp), nil
+}
+
+func tcsetpgrp(fd int, pgrp int) error {
+	p := int32(pgrp)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&p)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// signalByName looks up a signal by name, with or without the SIG prefix
+func signalByName(name string) (os.Signal, bool) {
+	sig, ok := map[string]syscall.Signal{
+		"HUP":  syscall.SIGHUP,
+		"INT":  syscall.SIGINT,
+		"QUIT": syscall.SIGQUIT,
+		"TERM": syscall.SIGTERM,
+		"USR1": syscall.SIGUSR1,
+		"USR2": syscall.SIGUSR2,
+	}[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	return sig, ok
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowWriter lags behind the command writing to it
+type slowWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *slowWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestChildWaitFlushesOutput(t *testing.T) {
+	out := &slowWriter{}
+	var mu sync.Mutex
+	ecmd := exec.Command("sh", "-c", "i=0; while [ $i -lt 200 ]; do echo line $i; i=$((i+1)); done; exit 3")
+	ecmd.Stdout = &prefixWriter{mu: &mu, out: out, prefix: "[dev] "}
+
+	c, err := startChild(ecmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := c.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.ExitStatus() != 3 {
+		t.Errorf("expected exit status 3, got %d", ws.ExitStatus())
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 200 {
+		t.Fatalf("expected all 200 lines to be written before the exit status is returned, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if expected := fmt.Sprintf("[dev] line %d", i); line != expected {
+			t.Fatalf("expected %q, got %q", expected, line)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// signals forwarded to the command
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// child is a running command
+type child struct {
+	cmd *exec.Cmd
+}
+
+func startChild(cmd *exec.Cmd) (*child, error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &child{cmd: cmd}, nil
+}
+
+// Signal sends sig to the command. Windows only supports os.Kill; consoles
+// deliver ^C to the command directly.
+func (c *child) Signal(sig os.Signal) error {
+	return c.cmd.Process.Signal(sig)
+}
+
+// Stop terminates the command; windows has no way to ask it to exit
+func (c *child) Stop() error {
+	return c.cmd.Process.Kill()
+}
+
+// Kill terminates the command
+func (c *child) Kill() error {
+	return c.cmd.Process.Kill()
+}
+
+// Wait waits for the command to exit and returns its status
+func (c *child) Wait() (syscall.WaitStatus, error) {
+	err := c.cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.Sys().(syscall.WaitStatus), nil
+	}
+	if err != nil {
+		return syscall.WaitStatus{}, err
+	}
+	return c.cmd.ProcessState.Sys().(syscall.WaitStatus), nil
+}
+
+// exitWithStatus exits with the command's exit code
+func exitWithStatus(ws syscall.WaitStatus) {
+	os.Exit(ws.ExitStatus())
+}
+
+func replaceProcess(command string, args []string, env []string) error {
+	return errors.New("--exec-replace is not supported on windows")
+}
+
+// signalByName always fails; commands can't be signalled on windows
+func signalByName(name string) (os.Signal, bool) {
+	return nil, false
+}
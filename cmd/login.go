@@ -12,7 +12,6 @@ import (
 	"github.com/99designs/keyring"
 	analytics "github.com/segmentio/analytics-go"
 	"github.com/segmentio/aws-okta/lib"
-	"github.com/skratchdot/open-golang/open"
 	"github.com/spf13/cobra"
 )
 
@@ -28,11 +27,51 @@ var loginCmd = &cobra.Command{
 // Stdout is the bool for -stdout
 var Stdout bool
 
+// How login signs in to the console
+const (
+	// exchange the profile's credentials for a sign-in token
+	loginMethodFederation = "federation"
+	// post the SAML assertion to AWS, with the role preselected
+	loginMethodSAML = "saml"
+	// open the okta app, which asks for a role
+	loginMethodOkta = "okta"
+)
+
+var (
+	loginMethod          string
+	loginDestination     string
+	loginConsoleDuration time.Duration
+	loginIssuer          string
+	loginPrintJSON       bool
+)
+
+// The federation endpoint's limits on the console session, and how long a
+// sign-in token can be used for
+const (
+	minConsoleDuration = 15 * time.Minute
+	maxConsoleDuration = 12 * time.Hour
+	signinTokenTTL     = 15 * time.Minute
+)
+
+// loginOutput is what login --print-json prints
+type loginOutput struct {
+	URL string `json:"url"`
+	// Expiration is when the URL stops working
+	Expiration *time.Time `json:"expiration,omitempty"`
+	// SessionExpiration is when the console session it starts ends
+	SessionExpiration *time.Time `json:"session_expiration,omitempty"`
+}
+
 func init() {
 	RootCmd.AddCommand(loginCmd)
 	loginCmd.Flags().BoolVarP(&Stdout, "stdout", "s", false, "Print login URL to stdout instead of opening in default browser")
 	loginCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	loginCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	loginCmd.Flags().StringVar(&loginMethod, "method", "", "How to sign in: federation, saml or okta (default: saml for profiles with aws_saml_url, federation otherwise)")
+	loginCmd.Flags().StringVar(&loginDestination, "destination", "", "Console page to open: a service such as s3, a console path or a full URL")
+	loginCmd.Flags().DurationVar(&loginConsoleDuration, "console-duration", 0, "Length of the console session, between 15m and 12h (default: the federation endpoint's)")
+	loginCmd.Flags().StringVar(&loginIssuer, "issuer", "aws-okta", "Issuer passed to the federation endpoint; the console links to it when the session expires")
+	loginCmd.Flags().BoolVar(&loginPrintJSON, "print-json", false, "Print the login URL and its expiry as JSON instead of opening a browser")
 }
 
 func loginPre(cmd *cobra.Command, args []string) {
@@ -85,6 +124,42 @@ func loginRun(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if !cmd.Flags().Lookup("console-duration").Changed {
+		if err := updateDurationFromConfigProfile(profiles, profile, "console_session_duration", &loginConsoleDuration); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse console_session_duration from profile config")
+		}
+	}
+	if loginConsoleDuration != 0 && (loginConsoleDuration < minConsoleDuration || loginConsoleDuration > maxConsoleDuration) {
+		return fmt.Errorf("console session duration must be between %s and %s", minConsoleDuration, maxConsoleDuration)
+	}
+
+	if !cmd.Flags().Lookup("method").Changed {
+		loginMethod, _, _ = profiles.GetValue(profile, "console_login_method")
+	}
+	if loginMethod == "" {
+		loginMethod = loginMethodFederation
+		if _, ok := prof["aws_saml_url"]; ok {
+			loginMethod = loginMethodSAML
+		}
+	}
+	switch loginMethod {
+	case loginMethodFederation, loginMethodOkta:
+	case loginMethodSAML:
+		// SAML can only sign in to roles in the assertion, not ones assumed
+		// from them
+		if prof["source_profile"] != "" && prof["role_arn"] != "" {
+			return fmt.Errorf("profile '%s' assumes its role from %s; use --method %s", profile, prof["source_profile"], loginMethodFederation)
+		}
+	default:
+		return fmt.Errorf("unknown login method %q; use %s, %s or %s", loginMethod, loginMethodFederation, loginMethodSAML, loginMethodOkta)
+	}
+
+	if !cmd.Flags().Lookup("issuer").Changed {
+		if issuer, _, err := profiles.GetValue(profile, "console_issuer"); err == nil {
+			loginIssuer = issuer
+		}
+	}
+
 	opts := lib.ProviderOptions{
 		MFAConfig:          mfaConfig,
 		Profiles:           profiles,
@@ -115,33 +190,39 @@ func loginRun(cmd *cobra.Command, args []string) error {
 
 	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
 
+	browser, err := lib.BrowserFromProfile(profiles, profile)
+	if err != nil {
+		return err
+	}
+
 	p, err := lib.NewProvider(kr, profile, opts)
 	if err != nil {
 		return err
 	}
 
-	if _, ok := prof["aws_saml_url"]; ok {
-		return oktaLogin(p)
+	switch loginMethod {
+	case loginMethodSAML:
+		return samlLogin(p, profile, profiles, browser)
+	case loginMethodOkta:
+		return oktaLogin(p, browser)
 	}
-	return federatedLogin(p, profile, profiles)
+	return federatedLogin(p, profile, profiles, browser)
 }
 
-func oktaLogin(p *lib.Provider) error {
+func oktaLogin(p *lib.Provider, browser lib.Browser) error {
 	loginURL, err := p.GetSAMLLoginURL()
 	if err != nil {
 		return err
 	}
 
-	if Stdout {
-		fmt.Println(loginURL.String())
-	} else if err := open.Run(loginURL.String()); err != nil {
-		return err
+	if loginDestination != "" || loginConsoleDuration != 0 {
+		fmt.Fprintln(os.Stderr, "warning: --destination and --console-duration are not supported by okta logins")
 	}
 
-	return nil
+	return showLoginURL(loginOutput{URL: loginURL.String()}, browser)
 }
 
-func federatedLogin(p *lib.Provider, profile string, profiles lib.Profiles) error {
+func federatedLogin(p *lib.Provider, profile string, profiles lib.Profiles, browser lib.Browser) error {
 	creds, err := p.Retrieve()
 	if err != nil {
 		return err
@@ -156,16 +237,21 @@ func federatedLogin(p *lib.Provider, profile string, profiles lib.Profiles) erro
 		return err
 	}
 
-	req, err := http.NewRequest("GET", "https://signin.aws.amazon.com/federation", nil)
+	partition := p.Partition()
+	req, err := http.NewRequest("GET", partition.FederationURL(), nil)
 	if err != nil {
 		return err
 	}
 	q := req.URL.Query()
 	q.Add("Action", "getSigninToken")
 	q.Add("Session", string(jsonBytes))
+	if loginConsoleDuration != 0 {
+		q.Add("SessionDuration", fmt.Sprintf("%d", int64(loginConsoleDuration/time.Second)))
+	}
 
 	req.URL.RawQuery = q.Encode()
 
+	issued := time.Now()
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -188,29 +274,52 @@ func federatedLogin(p *lib.Provider, profile string, profiles lib.Profiles) erro
 
 	signinToken, ok := respParsed["SigninToken"]
 	if !ok {
-		return err
+		return fmt.Errorf("getSigninToken response has no SigninToken")
 	}
 
-	destination := "https://console.aws.amazon.com/"
-	prof := profiles[profile]
-	if region, ok := prof["region"]; ok {
-		destination = fmt.Sprintf(
-			"https://%s.console.aws.amazon.com/console/home?region=%s",
-			region, region,
-		)
-	}
+	destination := partition.ConsoleDestination(loginDestination, profiles[profile]["region"])
 
 	loginURL := fmt.Sprintf(
-		"https://signin.aws.amazon.com/federation?Action=login&Issuer=aws-okta&Destination=%s&SigninToken=%s",
+		"%s?Action=login&Issuer=%s&Destination=%s&SigninToken=%s",
+		partition.FederationURL(),
+		url.QueryEscape(loginIssuer),
 		url.QueryEscape(destination),
 		url.QueryEscape(signinToken),
 	)
 
-	if Stdout {
-		fmt.Println(loginURL)
-	} else if err = open.Run(loginURL); err != nil {
-		return err
+	expiration := issued.Add(signinTokenTTL)
+	// without a SessionDuration the console session lasts as long as the
+	// credentials it was made from
+	sessionExpiration := p.GetExpiration()
+	if loginConsoleDuration != 0 {
+		sessionExpiration = issued.Add(loginConsoleDuration)
 	}
 
-	return nil
+	return showLoginURL(loginOutput{
+		URL:               loginURL,
+		Expiration:        &expiration,
+		SessionExpiration: &sessionExpiration,
+	}, browser)
+}
+
+// showLoginURL prints the login URL as requested, or opens it in the browser
+func showLoginURL(out loginOutput, browser lib.Browser) error {
+	switch {
+	case loginPrintJSON:
+		if out.Expiration != nil {
+			t := out.Expiration.UTC()
+			out.Expiration = &t
+		}
+		if out.SessionExpiration != nil {
+			t := out.SessionExpiration.UTC()
+			out.SessionExpiration = &t
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case Stdout:
+		fmt.Println(out.URL)
+		return nil
+	}
+	return browser.Open(out.URL)
 }
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/aws-okta/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// samlLoginPage posts a SAML assertion to the AWS sign-in endpoint as soon as
+// it loads. roleIndex preselects the role, so AWS doesn't ask for one.
+var samlLoginPage = template.Must(template.New("saml-login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<title>Signing in to AWS</title>
+</head>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="SAMLResponse" value="{{.SAMLResponse}}">
+<input type="hidden" name="roleIndex" value="{{.RoleIndex}}">
+{{if .RelayState}}<input type="hidden" name="RelayState" value="{{.RelayState}}">{{end}}
+<noscript><button type="submit">Sign in to AWS</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+type samlLoginForm struct {
+	Action       string
+	SAMLResponse string
+	RoleIndex    string
+	RelayState   string
+}
+
+// how long a SAML assertion is assumed to be valid for if it doesn't say
+const samlLoginTTL = 5 * time.Minute
+
+// samlLogin signs in to the console with the profile's SAML assertion, from a
+// page served once on localhost. Only the browser that opens the page's
+// unguessable URL gets the assertion.
+func samlLogin(p *lib.Provider, profile string, profiles lib.Profiles, browser lib.Browser) error {
+	if loginConsoleDuration != 0 {
+		fmt.Fprintln(os.Stderr, "warning: --console-duration is not supported by saml logins; the session lasts as long as your okta app allows")
+	}
+
+	signin, err := p.GetSAMLSignin()
+	if err != nil {
+		return err
+	}
+
+	partition, err := lib.PartitionFromARN(signin.Role)
+	if err != nil {
+		return err
+	}
+
+	region, _, _ := profiles.GetValue(profile, "region")
+	var page bytes.Buffer
+	err = samlLoginPage.Execute(&page, samlLoginForm{
+		Action:       partition.SAMLURL(),
+		SAMLResponse: string(signin.Assertion.RawData),
+		RoleIndex:    signin.Role,
+		RelayState:   partition.ConsoleDestination(loginDestination, region),
+	})
+	if err != nil {
+		return err
+	}
+
+	expiration := time.Now().Add(samlLoginTTL)
+	if signin.Assertion.Resp != nil {
+		notOnOrAfter, err := time.Parse(time.RFC3339, signin.Assertion.Resp.Assertion.Conditions.NotOnOrAfter)
+		if err == nil {
+			expiration = notOnOrAfter
+		}
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	path := "/" + hex.EncodeToString(token)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	served := make(chan struct{})
+	var once sync.Once
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		used := true
+		once.Do(func() {
+			used = false
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Referrer-Policy", "no-referrer")
+			w.Write(page.Bytes())
+			close(served)
+		})
+		if used {
+			http.Error(w, "this login URL has already been used; run aws-okta login again", http.StatusGone)
+		}
+	})}
+	go server.Serve(listener)
+	defer func() {
+		// let the page finish loading
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Debugf("failed to stop the login page server: %s", err)
+		}
+	}()
+
+	loginURL := fmt.Sprintf("http://%s%s", listener.Addr(), path)
+	if err := showLoginURL(loginOutput{URL: loginURL, Expiration: &expiration}, browser); err != nil {
+		return err
+	}
+	if Stdout || loginPrintJSON {
+		fmt.Fprintln(os.Stderr, "aws-okta: waiting for the login URL to be opened in a browser on this machine")
+	}
+
+	select {
+	case <-served:
+		return nil
+	case <-time.After(time.Until(expiration)):
+		return fmt.Errorf("the login URL was not opened before the SAML assertion expired")
+	}
+}
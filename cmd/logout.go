@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/99designs/keyring"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	"github.com/segmentio/aws-okta/sessioncache"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logoutAccount           string
+	logoutAll               bool
+	logoutRemoveCredentials bool
+)
+
+// logoutCmd represents the logout command
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "logout ends your okta session and removes cached sessions from your keyring",
+	RunE:  logoutRun,
+}
+
+func init() {
+	RootCmd.AddCommand(logoutCmd)
+	logoutCmd.Flags().StringVarP(&logoutAccount, "account", "", "", "Okta account to log out of, as given to add --account or shown by accounts (default: the default account)")
+	logoutCmd.Flags().BoolVarP(&logoutAll, "all", "", false, "Log out of every okta account")
+	logoutCmd.Flags().BoolVarP(&logoutRemoveCredentials, "remove-credentials", "", false, "Also remove the accounts' okta credentials from the keyring")
+}
+
+func logoutRun(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return ErrTooManyArguments
+	}
+	if logoutAll && logoutAccount != "" {
+		return fmt.Errorf("--account and --all can't be used together")
+	}
+	if lib.EnvCredentialsSet() {
+		return fmt.Errorf("%s is set, so okta sessions are only kept in memory; there's nothing to log out of", lib.EnvOktaUsername)
+	}
+
+	profiles, err := listProfiles()
+	if err != nil {
+		return err
+	}
+
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+	kr, err := lib.OpenKeyring(allowedBackends)
+	if err != nil {
+		return err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("command", "logout"),
+		})
+	}
+
+	accounts := []string{lib.ResolveAccount(kr, lib.AccountKey(logoutAccount))}
+	if logoutAll {
+		if accounts, err = keyringAccounts(kr); err != nil {
+			return err
+		}
+	}
+
+	// the profiles, session cookies and source profiles of each account
+	cookieKeys := map[string]map[string]bool{}
+	sources := map[string]bool{}
+	for _, account := range accounts {
+		cookieKeys[account] = map[string]bool{}
+	}
+	for profile := range profiles {
+		if profile == "okta" {
+			continue
+		}
+		account := lib.ResolveAccount(kr, profiles.OktaAccount(profile))
+		if _, ok := cookieKeys[account]; !ok {
+			continue
+		}
+		cookieKeys[account][profiles.SessionCookieKey(profile)] = true
+		sources[profiles.SourceProfile(profile)] = true
+	}
+
+	// profiles without an account use the default one, and its cookie
+	// key is theirs even when no profile is configured
+	defaultAccount := lib.ResolveAccount(kr, lib.DefaultOktaAccount)
+	for _, account := range accounts {
+		if account == defaultAccount && len(cookieKeys[account]) == 0 {
+			cookieKeys[account][lib.DefaultSessionCookieKey] = true
+		}
+		for cookieKey := range cookieKeys[account] {
+			closeOktaSession(kr, account, cookieKey)
+			removeKeyringItem(kr, cookieKey)
+		}
+		if logoutRemoveCredentials {
+			removeKeyringItem(kr, account)
+		}
+	}
+	if logoutAll {
+		// the device token is shared by every account
+		removeKeyringItem(kr, lib.DeviceTokenKey)
+	}
+
+	match := func(profile string) bool { return logoutAll || sources[profile] }
+	stores := []interface {
+		Clear(func(string) bool) (int, error)
+	}{
+		&sessioncache.KrItemPerSessionStore{Keyring: kr},
+		&sessioncache.SingleKrItemStore{Keyring: kr},
+	}
+	for _, store := range stores {
+		if _, err := store.Clear(match); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to clear cached sessions: %s\n", err)
+		}
+	}
+
+	if len(accounts) == 0 {
+		fmt.Fprintln(os.Stderr, "aws-okta: there are no okta accounts in your keyring")
+		return nil
+	}
+	names := make([]string, len(accounts))
+	for i, account := range accounts {
+		names[i] = lib.AccountName(account)
+	}
+	fmt.Fprintf(os.Stderr, "aws-okta: logged out of %s\n", strings.Join(names, ", "))
+	return nil
+}
+
+// closeOktaSession revokes the okta session whose cookie is stored under
+// cookieKey, using the domain of the account's credentials
+func closeOktaSession(kr keyring.Keyring, account, cookieKey string) {
+	cookie, err := kr.Get(cookieKey)
+	if err != nil || len(cookie.Data) == 0 {
+		log.Debugf("No okta session in %s: %v", cookieKey, err)
+		return
+	}
+
+	item, err := kr.Get(account)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: can't end the okta session in %s without the credentials of %s: %s\n", cookieKey, lib.AccountName(account), err)
+		return
+	}
+	var creds lib.OktaCreds
+	if err := json.Unmarshal(item.Data, &creds); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to read the credentials of %s: %s\n", lib.AccountName(account), err)
+		return
+	}
+
+	client, err := lib.NewOktaClient2(creds, "", lib.OktaCookies{Session: string(cookie.Data)}, lib.MFAConfig{})
+	if err == nil {
+		err = client.CloseSession()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to end the okta session of %s: %s\n", lib.AccountName(account), err)
+	}
+}
+
+func removeKeyringItem(kr keyring.Keyring, key string) {
+	err := kr.Remove(key)
+	if err != nil && err != keyring.ErrKeyNotFound && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "warning: failed to remove %s from the keyring: %s\n", key, err)
+	}
+}
+
+// keyringAccounts returns the keyring items of the okta accounts in the
+// keyring
+func keyringAccounts(kr keyring.Keyring) ([]string, error) {
+	list, err := lib.ListAccounts(kr)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]string, len(list))
+	for i, account := range list {
+		accounts[i] = account.Key
+	}
+	return accounts, nil
+}
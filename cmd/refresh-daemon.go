@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/mitchellh/go-homedir"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+	ini "gopkg.in/ini.v1"
+)
+
+var (
+	daemonProfiles       []string
+	daemonAll            bool
+	daemonCredsFile      string
+	daemonRefreshMargin  time.Duration
+	daemonNonInteractive bool
+)
+
+// refreshDaemonCmd represents the refresh-daemon command
+var refreshDaemonCmd = &cobra.Command{
+	Use:   "refresh-daemon (--profiles <profile,...> | --all)",
+	Short: "refresh-daemon keeps credentials for the specified profiles current in a shared credentials file",
+	RunE:  refreshDaemonRun,
+	Example: `aws-okta refresh-daemon --profiles dev,prod
+aws-okta refresh-daemon install --profiles dev,prod > ~/.config/systemd/user/aws-okta-refresh.service`,
+}
+
+// refreshDaemonInstallCmd represents the refresh-daemon install command
+var refreshDaemonInstallCmd = &cobra.Command{
+	Use:   "install (--profiles <profile,...> | --all)",
+	Short: "install prints a systemd user unit running refresh-daemon with the same flags",
+	RunE:  refreshDaemonInstallRun,
+}
+
+func init() {
+	RootCmd.AddCommand(refreshDaemonCmd)
+	refreshDaemonCmd.AddCommand(refreshDaemonInstallCmd)
+	flags := refreshDaemonCmd.PersistentFlags()
+	flags.DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
+	flags.DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	flags.StringSliceVarP(&daemonProfiles, "profiles", "", nil, "Comma separated profiles to keep credentials for")
+	flags.BoolVarP(&daemonAll, "all", "", false, "Keep credentials for every profile using okta")
+	flags.StringVarP(&daemonCredsFile, "credentials-file", "", "", "Credentials file to write (default: $AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials)")
+	flags.DurationVarP(&daemonRefreshMargin, "refresh-margin", "", 10*time.Minute, "How long before credentials expire to refresh them")
+	refreshDaemonCmd.Flags().BoolVarP(&daemonNonInteractive, "non-interactive", "", false, "Never prompt; wait for a session from another aws-okta instead (default when there's no terminal)")
+}
+
+// daemonProfile is a profile kept current by the daemon
+type daemonProfile struct {
+	name string
+	// when to next refresh its credentials
+	next time.Time
+}
+
+// daemonCredentialsFile returns the credentials file the daemon writes
+func daemonCredentialsFile() (string, error) {
+	path := daemonCredsFile
+	if path == "" {
+		path = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if path == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	return filepath.Abs(path)
+}
+
+// daemonSelectProfiles returns the profiles named by --profiles or --all
+func daemonSelectProfiles(profiles lib.Profiles) ([]string, error) {
+	switch {
+	case len(daemonProfiles) > 0 && daemonAll:
+		return nil, fmt.Errorf("--profiles and --all can't be used together")
+	case daemonAll:
+		selected := oktaProfiles(profiles)
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("no profiles in your aws config use okta")
+		}
+		return selected, nil
+	case len(daemonProfiles) > 0:
+		return selectProfiles(profiles, daemonProfiles, "")
+	}
+	return nil, fmt.Errorf("must specify --profiles or --all")
+}
+
+func refreshDaemonRun(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return ErrTooManyArguments
+	}
+	if daemonRefreshMargin <= 0 {
+		return fmt.Errorf("--refresh-margin must be positive")
+	}
+
+	credFilePath, err := daemonCredentialsFile()
+	if err != nil {
+		return err
+	}
+
+	config, err := lib.NewConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	profiles, err := config.Parse()
+	if err != nil {
+		return err
+	}
+
+	selected, err := daemonSelectProfiles(profiles)
+	if err != nil {
+		return err
+	}
+
+	if !cmd.Flags().Lookup("non-interactive").Changed {
+		daemonNonInteractive = !terminal.IsTerminal(int(os.Stdin.Fd())) || !terminal.IsTerminal(int(os.Stderr.Fd()))
+	}
+
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+
+	openKeyring := lib.OpenKeyring
+	if daemonNonInteractive {
+		openKeyring = lib.OpenKeyringNonInteractive
+	}
+	kr, err := openKeyring(allowedBackends)
+	if err != nil {
+		return err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("profile-count", len(selected)).
+				Set("command", "refresh-daemon"),
+		})
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, forwardedSignals...)
+	defer signal.Stop(sigChan)
+
+	daemon := make([]*daemonProfile, len(selected))
+	for i, name := range selected {
+		daemon[i] = &daemonProfile{name: name}
+	}
+
+	fmt.Fprintf(os.Stderr, "aws-okta: keeping credentials for %s current in %s\n", strings.Join(selected, ", "), credFilePath)
+	for {
+		refreshDaemonProfiles(cmd, kr, profiles, daemon, credFilePath)
+
+		next := daemon[0].next
+		for _, d := range daemon[1:] {
+			if d.next.Before(next) {
+				next = d.next
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case sig := <-sigChan:
+			timer.Stop()
+			fmt.Fprintf(os.Stderr, "aws-okta: received %s, exiting\n", sig)
+			return nil
+		}
+	}
+}
+
+// refreshDaemonProfiles refreshes the credentials of profiles that are due
+// and writes them to the credentials file. The okta session is reused, so MFA
+// is only needed when it has expired; if it has and we can't prompt, we try
+// again later, in case another aws-okta has logged in.
+func refreshDaemonProfiles(cmd *cobra.Command, kr keyring.Keyring, profiles lib.Profiles, daemon []*daemonProfile, credFilePath string) {
+	now := time.Now()
+	assertions := lib.NewSAMLAssertionCache()
+	written := map[string]profileCredentials{}
+
+	for _, d := range daemon {
+		if d.next.After(now) {
+			continue
+		}
+
+		creds, expiration, err := retrieveDaemonProfile(cmd, kr, profiles, d.name, assertions)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "aws-okta: failed to refresh credentials for %s, retrying in %s: %s\n", d.name, refreshRetryInterval, err)
+			d.next = now.Add(refreshRetryInterval)
+			continue
+		}
+
+		written[d.name] = profileCredentials{Value: creds, Expiration: expiration}
+		// credentials that don't outlive the margin are refreshed no more
+		// often than failed ones are retried
+		d.next = expiration.Add(-daemonRefreshMargin)
+		if earliest := now.Add(refreshRetryInterval); d.next.Before(earliest) {
+			d.next = earliest
+		}
+	}
+
+	if len(written) == 0 {
+		return
+	}
+
+	err := updateCredentialsFile(credFilePath, func(f *ini.File) error {
+		for profile, creds := range written {
+			setCredentialsSection(f, profile, creds)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "aws-okta: failed to write %s, retrying in %s: %s\n", credFilePath, refreshRetryInterval, err)
+		for name := range written {
+			for _, d := range daemon {
+				if d.name == name {
+					d.next = now.Add(refreshRetryInterval)
+				}
+			}
+		}
+		return
+	}
+
+	for name, creds := range written {
+		fmt.Fprintf(os.Stderr, "aws-okta: refreshed credentials for %s, valid until %s\n", name, creds.Expiration.Local().Format(time.RFC3339))
+	}
+}
+
+// retrieveDaemonProfile gets credentials for profile, treating cached
+// sessions that expire within the refresh margin as expired
+func retrieveDaemonProfile(cmd *cobra.Command, kr keyring.Keyring, profiles lib.Profiles, profile string, assertions *lib.SAMLAssertionCache) (credentials.Value, time.Time, error) {
+	p, err := profileProvider(cmd, kr, profiles, profile, assertions)
+	if err != nil {
+		return credentials.Value{}, time.Time{}, err
+	}
+	p.ExpiryWindow = daemonRefreshMargin
+	p.NonInteractive = daemonNonInteractive
+
+	creds, err := p.Retrieve()
+	if err != nil {
+		return credentials.Value{}, time.Time{}, err
+	}
+	return creds, p.GetExpiration(), nil
+}
+
+func refreshDaemonInstallRun(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return ErrTooManyArguments
+	}
+
+	profiles, err := listProfiles()
+	if err != nil {
+		return err
+	}
+	if _, err := daemonSelectProfiles(profiles); err != nil {
+		return err
+	}
+
+	credFilePath, err := daemonCredentialsFile()
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	command := []string{executable}
+	if backend != "" {
+		command = append(command, "--backend", backend)
+	}
+	if flagSessionCacheSingleItem {
+		command = append(command, "--session-cache-single-item")
+	}
+	command = append(command, "refresh-daemon", "--non-interactive", "--credentials-file", credFilePath,
+		"--refresh-margin", daemonRefreshMargin.String())
+	if daemonAll {
+		command = append(command, "--all")
+	} else {
+		command = append(command, "--profiles", strings.Join(daemonProfiles, ","))
+	}
+	for _, name := range []string{"session-ttl", "assume-role-ttl"} {
+		if flag := cmd.Flags().Lookup(name); flag.Changed {
+			command = append(command, "--"+name, flag.Value.String())
+		}
+	}
+
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = systemdQuote(arg)
+	}
+
+	fmt.Printf(systemdUnit, strings.Join(quoted, " "))
+	fmt.Fprintln(os.Stderr, "aws-okta: save this as ~/.config/systemd/user/aws-okta-refresh.service, then run: systemctl --user enable --now aws-okta-refresh")
+	return nil
+}
+
+const systemdUnit = `[Unit]
+Description=aws-okta credentials refresh
+After=network-online.target
+
+[Service]
+ExecStart=%s
+Restart=on-failure
+RestartSec=60
+
+[Install]
+WantedBy=default.target
+`
+
+// systemdQuote quotes s as a single argument of a systemd ExecStart line
+func systemdQuote(s string) string {
+	s = strings.Replace(s, "%", "%%", -1)
+	s = strings.Replace(s, "$", "$$", -1)
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\;") {
+		return s
+	}
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -33,6 +33,7 @@ const (
 var (
 	backend                    string
 	mfaConfig                  lib.MFAConfig
+	mfaFactors                 string
 	debug                      bool
 	version                    string
 	analyticsWriteKey          string
@@ -124,6 +125,8 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&mfaConfig.Provider, "mfa-provider", "", "", "MFA Provider to use (eg DUO, OKTA, GOOGLE)")
 	RootCmd.PersistentFlags().StringVarP(&mfaConfig.FactorType, "mfa-factor-type", "", "", "MFA Factor Type to use (eg push, token:software:totp)")
 	RootCmd.PersistentFlags().StringVarP(&mfaConfig.DuoDevice, "mfa-duo-device", "", "phone1", "Device to use phone1, phone2, u2f or token")
+	RootCmd.PersistentFlags().StringVarP(&mfaFactors, "mfa-factors", "", "", "MFA factors to try in order (eg OKTA:push, GOOGLE:token:software:totp)")
+	RootCmd.PersistentFlags().StringVarP(&mfaConfig.TokenCommand, "mfa-token-command", "", "", "Command printing a passcode for token MFA factors (eg ykman oath code -s okta)")
 	RootCmd.PersistentFlags().StringVarP(&backend, "backend", "b", "", fmt.Sprintf("Secret backend to use %s", backendsAvailable))
 	RootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging")
 	RootCmd.PersistentFlags().BoolVarP(&flagSessionCacheSingleItem, "session-cache-single-item", "", false, fmt.Sprintf("(alpha) Enable single-item session cache; aka %s", envSessionCacheSingleItem))
@@ -162,4 +165,26 @@ func updateMfaConfig(cmd *cobra.Command, profiles lib.Profiles, profile string,
 			}
 		}
 	}
+
+	if cmd.Flags().Lookup("mfa-factors").Changed {
+		config.Factors = lib.ParseMFAFactors(mfaFactors)
+	} else {
+		factors, ok := os.LookupEnv("AWS_OKTA_MFA_FACTORS")
+		if !ok {
+			factors, _, _ = profiles.GetValue(profile, "mfa_factors")
+		}
+		config.Factors = lib.ParseMFAFactors(factors)
+	}
+
+	if !cmd.Flags().Lookup("mfa-token-command").Changed {
+		mfaTokenCommand, ok := os.LookupEnv("AWS_OKTA_MFA_TOKEN_COMMAND")
+		if ok {
+			config.TokenCommand = mfaTokenCommand
+		} else {
+			mfaTokenCommand, _, err := profiles.GetValue(profile, "mfa_token_command")
+			if err == nil {
+				config.TokenCommand = mfaTokenCommand
+			}
+		}
+	}
 }
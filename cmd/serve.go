@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/99designs/keyring"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	"github.com/segmentio/aws-okta/lib/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveIMDS             bool
+	serveAddress          string
+	serveIMDSHopLimit     int
+	serveIMDSRequireToken bool
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:       "serve --imds <profile>",
+	Short:     "serve runs a local credentials endpoint for the specified profile until interrupted",
+	RunE:      serveRun,
+	PreRun:    servePre,
+	Example:   "aws-okta serve --imds test\nAWS_EC2_METADATA_SERVICE_ENDPOINT=http://127.0.0.1:9911 aws s3 ls",
+	ValidArgs: listProfileNames(mustListProfiles()),
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
+	serveCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	serveCmd.Flags().BoolVarP(&serveIMDS, "imds", "", false, "Emulate the EC2 instance metadata service (IMDSv1 and IMDSv2)")
+	serveCmd.Flags().StringVarP(&serveAddress, "address", "", "127.0.0.1:9911", "Address to listen on")
+	serveCmd.Flags().IntVarP(&serveIMDSHopLimit, "imds-hop-limit", "", server.DefaultIMDSHopLimit, "IP hop limit for IMDSv2 token responses; 0 to leave the system default")
+	serveCmd.Flags().BoolVarP(&serveIMDSRequireToken, "imds-require-token", "", true, "Reject IMDSv1 requests that don't carry an IMDSv2 session token; set to false for clients that only speak IMDSv1")
+}
+
+func servePre(cmd *cobra.Command, args []string) {
+	if err := loadDurationFlagFromEnv(cmd, "session-ttl", "AWS_SESSION_TTL", &sessionTTL); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: failed to parse duration from AWS_SESSION_TTL")
+	}
+
+	if err := loadDurationFlagFromEnv(cmd, "assume-role-ttl", "AWS_ASSUME_ROLE_TTL", &assumeRoleTTL); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: failed to parse duration from AWS_ASSUME_ROLE_TTL")
+	}
+}
+
+func serveRun(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return ErrTooFewArguments
+	}
+	if len(args) > 1 {
+		return ErrTooManyArguments
+	}
+
+	if !serveIMDS {
+		return fmt.Errorf("must specify what to serve (--imds)")
+	}
+
+	profile := args[0]
+	config, err := lib.NewConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	profiles, err := config.Parse()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := profiles[profile]; !ok {
+		return fmt.Errorf("Profile '%s' not found in your aws config. Use list command to see configured profiles", profile)
+	}
+
+	updateMfaConfig(cmd, profiles, profile, &mfaConfig)
+
+	// check profile for both session durations if not explicitly set
+	if !cmd.Flags().Lookup("assume-role-ttl").Changed {
+		if err := updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &assumeRoleTTL); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse assume_role_ttl from profile config")
+		}
+	}
+
+	if !cmd.Flags().Lookup("session-ttl").Changed {
+		if err := updateDurationFromConfigProfile(profiles, profile, "session_ttl", &sessionTTL); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse session_ttl from profile config")
+		}
+	}
+
+	opts := lib.ProviderOptions{
+		MFAConfig:          mfaConfig,
+		Profiles:           profiles,
+		SessionDuration:    sessionTTL,
+		AssumeRoleDuration: assumeRoleTTL,
+	}
+
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+
+	kr, err := lib.OpenKeyring(allowedBackends)
+	if err != nil {
+		return err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("profile", profile).
+				Set("command", "serve"),
+		})
+	}
+
+	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
+
+	p, err := lib.NewProvider(kr, profile, opts)
+	if err != nil {
+		return err
+	}
+
+	// retrieve once up front so that any MFA prompt happens before we start
+	// serving, and so we know which role to advertise
+	creds, err := p.Retrieve()
+	if err != nil {
+		return err
+	}
+
+	roleARN, err := p.GetRoleARNWithRegion(creds)
+	if err != nil {
+		return err
+	}
+	role := strings.Split(roleARN, "/")[1]
+
+	var partition, accountID string
+	if parts := strings.Split(roleARN, ":"); len(parts) > 4 {
+		partition, accountID = parts[1], parts[4]
+	}
+
+	region, _, _ := profiles.GetValue(profile, "region")
+
+	srv, err := server.NewIMDSServer(p, server.IMDSConfig{
+		Addr:         serveAddress,
+		RoleName:     role,
+		AccountID:    accountID,
+		Region:       region,
+		Partition:    partition,
+		HopLimit:     serveIMDSHopLimit,
+		RequireToken: serveIMDSRequireToken,
+	})
+	if err != nil {
+		return err
+	}
+	srv.Seed(creds)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-sigChan
+		log.Debugf("Received %s, shutting down", sig)
+		srv.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving instance metadata for %s on %s\n", roleARN, srv.URL())
+	fmt.Fprintf(os.Stderr, "Point SDKs at it with AWS_EC2_METADATA_SERVICE_ENDPOINT=%s\n", srv.URL())
+
+	return srv.Serve()
+}
@@ -0,0 +1,466 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/alessio/shellescape"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+// Environment variables used to find the parent aws-okta of a shell
+const (
+	envShellSocket = "AWS_OKTA_SHELL_SOCKET"
+	envShellToken  = "AWS_OKTA_SHELL_TOKEN"
+	// the profiles of the shells we are nested in, eg outer>inner
+	envShellStack = "AWS_OKTA_SHELL_STACK"
+)
+
+var shellStack bool
+
+// shellCmd represents the shell command
+var shellCmd = &cobra.Command{
+	Use:       "shell <profile>",
+	Short:     "shell starts your shell with aws credentials set in the environment",
+	RunE:      shellRun,
+	PreRun:    execPre,
+	ValidArgs: listProfileNames(mustListProfiles()),
+}
+
+// shellRefreshCmd represents the shell refresh command
+var shellRefreshCmd = &cobra.Command{
+	Use:     "refresh",
+	Short:   "refresh prints commands that update the credentials of the aws-okta shell it is run in",
+	RunE:    shellRefreshRun,
+	Example: `eval "$(aws-okta shell refresh)"`,
+}
+
+func init() {
+	RootCmd.AddCommand(shellCmd)
+	shellCmd.AddCommand(shellRefreshCmd)
+	shellCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
+	shellCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	shellCmd.Flags().StringVarP(&assumeRoleARN, "assume-role-arn", "r", "", "Role arn to assume, overrides arn in profile")
+	shellCmd.Flags().BoolVarP(&shellStack, "stack", "", false, "Allow starting a shell from within another aws-okta shell or exec")
+}
+
+func shellRun(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return ErrTooFewArguments
+	}
+	if len(args) > 1 {
+		return ErrTooManyArguments
+	}
+
+	profile := args[0]
+
+	// The outer credentials would be replaced in the new shell, which is
+	// easy to lose track of, so nesting must be asked for
+	stack := profile
+	if outer := os.Getenv("AWS_OKTA_PROFILE"); outer != "" {
+		if !shellStack {
+			return fmt.Errorf("already running with credentials for %s; exit that shell first or use --stack", outer)
+		}
+		if outerStack := os.Getenv(envShellStack); outerStack != "" {
+			outer = outerStack
+		}
+		stack = outer + ">" + profile
+	}
+
+	config, err := lib.NewConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	profiles, err := config.Parse()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := profiles[profile]; !ok {
+		return fmt.Errorf("Profile '%s' not found in your aws config. Use list command to see configured profiles.", profile)
+	}
+
+	updateMfaConfig(cmd, profiles, profile, &mfaConfig)
+
+	// check profile for both session durations if not explicitly set
+	if !cmd.Flags().Lookup("assume-role-ttl").Changed {
+		if err := updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &assumeRoleTTL); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse assume_role_ttl from profile config")
+		}
+	}
+
+	if !cmd.Flags().Lookup("session-ttl").Changed {
+		if err := updateDurationFromConfigProfile(profiles, profile, "session_ttl", &sessionTTL); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse session_ttl from profile config")
+		}
+	}
+
+	opts := lib.ProviderOptions{
+		MFAConfig:          mfaConfig,
+		Profiles:           profiles,
+		SessionDuration:    sessionTTL,
+		AssumeRoleDuration: assumeRoleTTL,
+		AssumeRoleArn:      assumeRoleARN,
+	}
+
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+
+	kr, err := lib.OpenKeyring(allowedBackends)
+	if err != nil {
+		return err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("profile", profile).
+				Set("command", "shell"),
+		})
+	}
+
+	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
+
+	p, err := lib.NewProvider(kr, profile, opts)
+	if err != nil {
+		return err
+	}
+
+	creds, err := p.Retrieve()
+	if err != nil {
+		return err
+	}
+
+	roleARN, err := p.GetRoleARNWithRegion(creds)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir(os.Getenv("XDG_RUNTIME_DIR"), "aws-okta-shell-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// the shell owns the terminal, so refreshing can't prompt; if okta needs
+	// the user again, `shell refresh` fails and says so
+	p.NonInteractive = true
+
+	srv, err := newShellServer(dir, profile, p)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	go srv.Serve()
+
+	env := commandEnv(profile, profiles, roleARN)
+	setCredentialsEnv(&env, creds, p.GetExpiration())
+	env.Set(envShellStack, stack)
+	env.Set(envShellSocket, srv.Addr())
+	env.Set(envShellToken, srv.token)
+
+	shell, shellArgs, err := shellCommand(dir, &env)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "aws-okta: starting %s with credentials for %s\n", shell, profile)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, forwardedSignals...)
+	defer signal.Stop(sigChan)
+	waitStatus, err := superviseCommand(shell, shellArgs, env, sigChan, p, profile, refreshPolicy{}, nil)
+	if err != nil {
+		return err
+	}
+	if waitStatus.Signaled() || waitStatus.ExitStatus() != 0 {
+		// exiting skips deferred cleanup
+		srv.Close()
+		os.RemoveAll(dir)
+		exitWithStatus(waitStatus)
+	}
+	return nil
+}
+
+// shellCommand returns the user's shell and the arguments that start it with
+// our prompt prefix and aws-okta-refresh function. Any files needed go in dir.
+func shellCommand(dir string, env *environ) (string, []string, error) {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = defaultShell()
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return "", nil, err
+	}
+
+	refresh := fmt.Sprintf("eval \"$(%s shell refresh)\"", shellescape.Quote(self))
+
+	switch filepath.Base(shell) {
+	case "bash":
+		rc := filepath.Join(dir, "bashrc")
+		content := `[ -f ~/.bashrc ] && . ~/.bashrc
+` + posixPrompt + `
+aws-okta-refresh() { ` + refresh + `; }
+PS1='$(__aws_okta_prompt)'"$PS1"
+`
+		if err := ioutil.WriteFile(rc, []byte(content), 0600); err != nil {
+			return "", nil, err
+		}
+		return shell, []string{"--rcfile", rc, "-i"}, nil
+	case "zsh":
+		// zsh reads its startup files from ZDOTDIR; ours read the user's,
+		// then add to the prompt
+		orig := os.Getenv("ZDOTDIR")
+		if orig == "" {
+			orig = os.Getenv("HOME")
+		}
+		env.Set("AWS_OKTA_ZDOTDIR", orig)
+		env.Set("ZDOTDIR", dir)
+		files := map[string]string{
+			".zshenv": `[ -f "$AWS_OKTA_ZDOTDIR/.zshenv" ] && . "$AWS_OKTA_ZDOTDIR/.zshenv"
+`,
+			".zshrc": `ZDOTDIR="$AWS_OKTA_ZDOTDIR"
+unset AWS_OKTA_ZDOTDIR
+[ -f "$ZDOTDIR/.zshrc" ] && . "$ZDOTDIR/.zshrc"
+` + posixPrompt + `
+aws-okta-refresh() { ` + refresh + `; }
+setopt PROMPT_SUBST
+PROMPT='$(__aws_okta_prompt)'"$PROMPT"
+`,
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+				return "", nil, err
+			}
+		}
+		return shell, []string{"-i"}, nil
+	case "fish":
+		init := fishPrompt + fmt.Sprintf("\nfunction aws-okta-refresh; %s shell refresh | source; end\n", shellescape.Quote(self))
+		return shell, []string{"-i", "--init-command", init}, nil
+	}
+
+	// For other shells, the best we can do is a static prefix
+	prefix := fmt.Sprintf("[%s:%s] ", envValue(*env, envShellStack), envValue(*env, "AWS_OKTA_ASSUMED_ROLE"))
+	switch strings.ToLower(filepath.Base(shell)) {
+	case "cmd.exe", "cmd":
+		prompt := envValue(*env, "PROMPT")
+		if prompt == "" {
+			prompt = "$P$G"
+		}
+		env.Set("PROMPT", prefix+prompt)
+	default:
+		ps1 := envValue(*env, "PS1")
+		if ps1 == "" {
+			ps1 = "$ "
+		}
+		env.Set("PS1", prefix+ps1)
+	}
+	return shell, nil, nil
+}
+
+// posixPrompt defines __aws_okta_prompt, which prints the prompt prefix in
+// bash and zsh
+const posixPrompt = `__aws_okta_prompt() {
+  local remaining=$(( (${AWS_OKTA_SESSION_EXPIRATION:-0} - $(date +%s)) / 60 ))
+  if [ "$remaining" -gt 0 ]; then
+    printf '[%s:%s %dm] ' "$AWS_OKTA_SHELL_STACK" "$AWS_OKTA_ASSUMED_ROLE" "$remaining"
+  else
+    printf '[%s:%s expired] ' "$AWS_OKTA_SHELL_STACK" "$AWS_OKTA_ASSUMED_ROLE"
+  fi
+}`
+
+// fishPrompt wraps fish_prompt to print the prompt prefix
+const fishPrompt = `functions -q fish_prompt; and functions -c fish_prompt __aws_okta_fish_prompt
+function fish_prompt
+  set -l remaining (math --scale=0 "($AWS_OKTA_SESSION_EXPIRATION - "(date +%s)") / 60")
+  if test $remaining -gt 0
+    printf '[%s:%s %dm] ' $AWS_OKTA_SHELL_STACK $AWS_OKTA_ASSUMED_ROLE $remaining
+  else
+    printf '[%s:%s expired] ' $AWS_OKTA_SHELL_STACK $AWS_OKTA_ASSUMED_ROLE
+  end
+  functions -q __aws_okta_fish_prompt; and __aws_okta_fish_prompt
+end`
+
+// envValue returns the value of key in env
+func envValue(env environ, key string) string {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return strings.TrimPrefix(kv, key+"=")
+		}
+	}
+	return ""
+}
+
+// shellCredentials is what the shell server sends a refresh request
+type shellCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+	Error           string `json:",omitempty"`
+}
+
+// shellServer hands refreshed credentials to `aws-okta shell refresh` running
+// in the shell. Requests are a single line holding the token; the response is
+// shellCredentials as JSON.
+type shellServer struct {
+	listener net.Listener
+	token    string
+	profile  string
+
+	mu       sync.Mutex
+	provider *lib.Provider
+}
+
+func newShellServer(dir string, profile string, p *lib.Provider) (*shellServer, error) {
+	l, err := listenShell(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := randomShellToken()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return &shellServer{listener: l, token: token, profile: profile, provider: p}, nil
+}
+
+// Addr is where the server listens, as understood by dialShell
+func (s *shellServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *shellServer) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *shellServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *shellServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+
+	token, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		log.Debugf("failed reading shell refresh request: %s", err)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.token)) != 1 {
+		json.NewEncoder(conn).Encode(shellCredentials{Error: "invalid token"})
+		return
+	}
+
+	// the provider isn't safe for concurrent use
+	s.mu.Lock()
+	creds, err := s.provider.Retrieve()
+	expiration := s.provider.GetExpiration()
+	s.mu.Unlock()
+
+	if err != nil {
+		var interactionErr *lib.InteractionRequiredError
+		if xerrors.As(err, &interactionErr) {
+			err = fmt.Errorf("%s; run `aws-okta login %s` in another terminal, then try again", err, s.profile)
+		}
+		json.NewEncoder(conn).Encode(shellCredentials{Error: err.Error()})
+		return
+	}
+	json.NewEncoder(conn).Encode(shellCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      expiration,
+	})
+}
+
+func randomShellToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+func shellRefreshRun(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return ErrTooManyArguments
+	}
+
+	addr := os.Getenv(envShellSocket)
+	if addr == "" {
+		return errors.New("not running in an aws-okta shell")
+	}
+
+	conn, err := dialShell(addr)
+	if err != nil {
+		return fmt.Errorf("failed to reach aws-okta shell: %s", err)
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintln(conn, os.Getenv(envShellToken)); err != nil {
+		return err
+	}
+
+	var resp shellCredentials
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read refreshed credentials: %s", err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("failed to refresh credentials: %s", resp.Error)
+	}
+
+	env := environ{}
+	setCredentialsEnv(&env, credentials.Value{
+		AccessKeyID:     resp.AccessKeyID,
+		SecretAccessKey: resp.SecretAccessKey,
+		SessionToken:    resp.SessionToken,
+	}, resp.Expiration)
+
+	var vars []envVar
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		vars = append(vars, envVar{Name: parts[0], Value: parts[1]})
+	}
+	if err := writeEnv(os.Stdout, detectEnvFormat(), vars, false); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "aws-okta: credentials refreshed, expiring at %s\n", resp.Expiration.Local().Format(time.Kitchen))
+	return nil
+}
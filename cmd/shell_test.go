@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/segmentio/aws-okta/lib"
+	"github.com/segmentio/aws-okta/sessioncache"
+)
+
+func TestShellCommand(t *testing.T) {
+	defer os.Setenv("SHELL", os.Getenv("SHELL"))
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(t *testing.T, path string) string {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	t.Run("bash", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "aws-okta-shell-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		os.Setenv("SHELL", "/bin/bash")
+
+		env := environ{}
+		shell, args, err := shellCommand(dir, &env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc := filepath.Join(dir, "bashrc")
+		if shell != "/bin/bash" || !reflect.DeepEqual(args, []string{"--rcfile", rc, "-i"}) {
+			t.Errorf("unexpected command %s %q", shell, args)
+		}
+		content := read(t, rc)
+		for _, want := range []string{". ~/.bashrc", "__aws_okta_prompt()", "aws-okta-refresh() { eval \"$(" + self + " shell refresh)\"; }", "PS1='$(__aws_okta_prompt)'\"$PS1\""} {
+			if !strings.Contains(content, want) {
+				t.Errorf("expected the bashrc to contain %q, got:\n%s", want, content)
+			}
+		}
+	})
+
+	t.Run("zsh", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "aws-okta-shell-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		os.Setenv("SHELL", "/usr/bin/zsh")
+
+		env := environ{}
+		_, args, err := shellCommand(dir, &env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, []string{"-i"}) {
+			t.Errorf("unexpected arguments %q", args)
+		}
+		if zdotdir := envValue(env, "ZDOTDIR"); zdotdir != dir {
+			t.Errorf("expected ZDOTDIR to be %s, got %s", dir, zdotdir)
+		}
+		if envValue(env, "AWS_OKTA_ZDOTDIR") == "" {
+			t.Error("expected the user's ZDOTDIR to be kept")
+		}
+		if zshenv := read(t, filepath.Join(dir, ".zshenv")); !strings.Contains(zshenv, `. "$AWS_OKTA_ZDOTDIR/.zshenv"`) {
+			t.Errorf("expected the .zshenv to read the user's, got:\n%s", zshenv)
+		}
+		zshrc := read(t, filepath.Join(dir, ".zshrc"))
+		for _, want := range []string{`ZDOTDIR="$AWS_OKTA_ZDOTDIR"`, `. "$ZDOTDIR/.zshrc"`, "aws-okta-refresh()", "setopt PROMPT_SUBST"} {
+			if !strings.Contains(zshrc, want) {
+				t.Errorf("expected the .zshrc to contain %q, got:\n%s", want, zshrc)
+			}
+		}
+	})
+
+	t.Run("fish", func(t *testing.T) {
+		os.Setenv("SHELL", "/usr/bin/fish")
+		_, args, err := shellCommand("", &environ{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(args) != 3 || args[1] != "--init-command" || !strings.Contains(args[2], "function aws-okta-refresh; "+self+" shell refresh | source; end") {
+			t.Errorf("unexpected arguments %q", args)
+		}
+	})
+
+	t.Run("other shells", func(t *testing.T) {
+		os.Setenv("SHELL", "/bin/sh")
+		env := environ{envShellStack + "=dev", "AWS_OKTA_ASSUMED_ROLE=admin", "PS1=% "}
+		_, args, err := shellCommand("", &env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args != nil {
+			t.Errorf("unexpected arguments %q", args)
+		}
+		if ps1 := envValue(env, "PS1"); ps1 != "[dev:admin] % " {
+			t.Errorf("expected a static prompt prefix, got %q", ps1)
+		}
+	})
+}
+
+// shellRequest sends token to s and returns its response
+func shellRequest(t *testing.T, s *shellServer, token string) shellCredentials {
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handle(server)
+
+	if _, err := fmt.Fprintln(client, token); err != nil {
+		t.Fatal(err)
+	}
+	var resp shellCredentials
+	if err := json.NewDecoder(client).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestShellServerToken(t *testing.T) {
+	profiles := lib.Profiles{"dev": {"aws_saml_url": "home/amazon_aws/xyz/123"}}
+	kr := keyring.NewArrayKeyring(nil)
+	p, err := lib.NewProvider(kr, "dev", lib.ProviderOptions{Profiles: profiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	key := sessioncache.KeyWithProfileARN{ProfileName: "dev", ProfileConf: profiles["dev"], Duration: lib.DefaultSessionDuration}
+	err = (&sessioncache.KrItemPerSessionStore{Keyring: kr}).Put(key, &sessioncache.Session{Name: "someone", Credentials: sts.Credentials{
+		AccessKeyId:     aws.String("AKIDSHELL"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(expiration),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &shellServer{token: "secret-token", profile: "dev", provider: p}
+
+	if resp := shellRequest(t, s, "not-the-token"); resp.Error != "invalid token" || resp.AccessKeyID != "" {
+		t.Errorf("expected a wrong token to be rejected, got %+v", resp)
+	}
+	if resp := shellRequest(t, s, ""); resp.Error != "invalid token" {
+		t.Errorf("expected a missing token to be rejected, got %+v", resp)
+	}
+
+	resp := shellRequest(t, s, "secret-token")
+	if resp.Error != "" || resp.AccessKeyID != "AKIDSHELL" || !resp.Expiration.Equal(expiration) {
+		t.Errorf("expected the cached credentials, got %+v", resp)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"net"
+	"path/filepath"
+)
+
+// listenShell listens on a unix socket in dir, which only we can access
+func listenShell(dir string) (net.Listener, error) {
+	return net.Listen("unix", filepath.Join(dir, "socket"))
+}
+
+func dialShell(addr string) (net.Conn, error) {
+	return net.Dial("unix", addr)
+}
+
+func defaultShell() string {
+	return "/bin/sh"
+}
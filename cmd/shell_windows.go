@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"net"
+	"os"
+)
+
+// listenShell listens on the loopback interface, as unix sockets aren't
+// available; requests are authenticated by the shell's token
+func listenShell(dir string) (net.Listener, error) {
+	return net.Listen("tcp", "127.0.0.1:0")
+}
+
+func dialShell(addr string) (net.Conn, error) {
+	return net.Dial("tcp", addr)
+}
+
+func defaultShell() string {
+	if comspec := os.Getenv("COMSPEC"); comspec != "" {
+		return comspec
+	}
+	return "cmd.exe"
+}
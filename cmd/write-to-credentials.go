@@ -7,24 +7,45 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/internal/filelock"
 	"github.com/segmentio/aws-okta/lib"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
 	ini "gopkg.in/ini.v1"
 )
 
+// expirationKey records when the credentials in a section expire
+const expirationKey = "x_aws_okta_expiration"
+
+// how long to wait for another aws-okta writing the same credentials file
+const credentialsFileLockTimeout = 30 * time.Second
+
+var (
+	writeCredsProfiles []string
+	writeCredsAll      bool
+	writeCredsRemove   bool
+)
+
 // writeToCredentialsCmd represents the write-to-credentials command
 var writeToCredentialsCmd = &cobra.Command{
-	Use: "write-to-credentials <profile> <credentials-file>",
+	Use: "write-to-credentials (<profile> | --profiles <profile,...> | --all) <credentials-file>",
 	// N.B. The credentials file is a required argument so that the command makes
 	// it clear which file will be written to.
-	Short:     "write-to-credentials writes credentials for the specified profile to the specified credentials file",
-	RunE:      writeToCredentialsRun,
-	Example:   "aws-okta write-to-credentials test ~/.aws/credentials",
+	Short: "write-to-credentials writes credentials for the specified profile to the specified credentials file",
+	RunE:  writeToCredentialsRun,
+	Example: `aws-okta write-to-credentials test ~/.aws/credentials
+aws-okta write-to-credentials --profiles dev,prod ~/.aws/credentials
+aws-okta write-to-credentials --remove --all ~/.aws/credentials`,
 	ValidArgs: listProfileNames(mustListProfiles()),
 }
 
@@ -32,14 +53,36 @@ func init() {
 	RootCmd.AddCommand(writeToCredentialsCmd)
 	writeToCredentialsCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	writeToCredentialsCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	writeToCredentialsCmd.Flags().StringSliceVarP(&writeCredsProfiles, "profiles", "", nil, "Comma separated profiles to write credentials for")
+	writeToCredentialsCmd.Flags().BoolVarP(&writeCredsAll, "all", "", false, "Write credentials for every profile using okta; with --remove, remove every section written by aws-okta")
+	writeToCredentialsCmd.Flags().BoolVarP(&writeCredsRemove, "remove", "", false, "Remove the profiles' sections from the credentials file instead")
 }
 
 func writeToCredentialsRun(cmd *cobra.Command, args []string) error {
-	if len(args) < 2 {
+	bulk := len(writeCredsProfiles) > 0 || writeCredsAll
+	if bulk && len(args) > 1 || len(args) > 2 {
+		return ErrTooManyArguments
+	}
+	if bulk && len(args) < 1 || !bulk && len(args) < 2 {
 		return ErrTooFewArguments
 	}
+	if len(writeCredsProfiles) > 0 && writeCredsAll {
+		return fmt.Errorf("--profiles and --all can't be used together")
+	}
+
+	credFilePath := args[len(args)-1]
+	selected := writeCredsProfiles
+	if !bulk {
+		selected = []string{args[0]}
+	}
+
+	if writeCredsRemove {
+		return updateCredentialsFile(credFilePath, func(f *ini.File) error {
+			removeCredentialsSections(f, selected, writeCredsAll)
+			return nil
+		})
+	}
 
-	profile := args[0]
 	config, err := lib.NewConfigFromEnv()
 	if err != nil {
 		return err
@@ -50,38 +93,18 @@ func writeToCredentialsRun(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if _, ok := profiles[profile]; !ok {
-		return fmt.Errorf("Profile '%s' not found in your aws config. Use list command to see configured profiles", profile)
-	}
-
-	updateMfaConfig(cmd, profiles, profile, &mfaConfig)
-
-	// check profile for both session durations if not explicitly set
-	if !cmd.Flags().Lookup("assume-role-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &assumeRoleTTL); err != nil {
-			fmt.Fprintln(os.Stderr, "warning: could not parse assume_role_ttl from profile config")
+	if writeCredsAll {
+		selected = oktaProfiles(profiles)
+		if len(selected) == 0 {
+			return fmt.Errorf("no profiles in your aws config use okta")
 		}
 	}
-
-	if !cmd.Flags().Lookup("session-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "session_ttl", &sessionTTL); err != nil {
-			fmt.Fprintln(os.Stderr, "warning: could not parse session_ttl from profile config")
+	for _, profile := range selected {
+		if _, ok := profiles[profile]; !ok {
+			return fmt.Errorf("Profile '%s' not found in your aws config. Use list command to see configured profiles", profile)
 		}
 	}
 
-	credFilePath := args[1]
-	_, err = os.Stat(credFilePath)
-	if err != nil {
-		return err
-	}
-
-	opts := lib.ProviderOptions{
-		MFAConfig:          mfaConfig,
-		Profiles:           profiles,
-		SessionDuration:    sessionTTL,
-		AssumeRoleDuration: assumeRoleTTL,
-	}
-
 	var allowedBackends []keyring.BackendType
 	if backend != "" {
 		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
@@ -93,50 +116,200 @@ func writeToCredentialsRun(cmd *cobra.Command, args []string) error {
 	}
 
 	if analyticsEnabled && analyticsClient != nil {
+		props := analytics.NewProperties().
+			Set("backend", backend).
+			Set("aws-okta-version", version).
+			Set("command", "writeToCredentials")
+		if bulk {
+			props.Set("profile-count", len(selected))
+		} else {
+			props.Set("profile", selected[0])
+		}
 		analyticsClient.Enqueue(analytics.Track{
-			UserId: username,
-			Event:  "Ran Command",
-			Properties: analytics.NewProperties().
-				Set("backend", backend).
-				Set("aws-okta-version", version).
-				Set("profile", profile).
-				Set("command", "writeToCredentials"),
+			UserId:     username,
+			Event:      "Ran Command",
+			Properties: props,
 		})
 	}
 
-	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
+	// Credentials are retrieved one profile at a time, so that only the first
+	// needs to authenticate with okta; the rest reuse its SAML assertion.
+	assertions := lib.NewSAMLAssertionCache()
+	written := map[string]profileCredentials{}
+	var failed []string
+	for _, profile := range selected {
+		p, err := profileProvider(cmd, kr, profiles, profile, assertions)
+		if err != nil {
+			return err
+		}
+		creds, err := p.Retrieve()
+		if err != nil {
+			if !bulk {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "aws-okta: failed to get credentials for %s: %s\n", profile, err)
+			failed = append(failed, profile)
+			continue
+		}
+		written[profile] = profileCredentials{Value: creds, Expiration: p.GetExpiration()}
+	}
 
-	p, err := lib.NewProvider(kr, profile, opts)
-	if err != nil {
-		return err
+	if len(written) > 0 {
+		err := updateCredentialsFile(credFilePath, func(f *ini.File) error {
+			for profile, creds := range written {
+				setCredentialsSection(f, profile, creds)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	creds, err := p.Retrieve()
-	if err != nil {
-		return err
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to get credentials for %d of %d profiles", len(failed), len(selected))
 	}
-	credIni, err := ini.Load(credFilePath)
-	if err != nil {
-		return err
+	return nil
+}
+
+// profileCredentials are credentials and when they expire
+type profileCredentials struct {
+	credentials.Value
+	Expiration time.Time
+}
+
+// oktaProfiles returns the profiles that get their credentials through okta,
+// sorted. Those are the ones setting aws_saml_url or role_arn, themselves or
+// through their source_profile, with an aws_saml_url to log in with, which
+// may come from [okta]. Other profiles are left alone, even though
+// GetValue finds the [okta] aws_saml_url for them too.
+func oktaProfiles(profiles lib.Profiles) []string {
+	var names []string
+	for name := range profiles {
+		// okta is where defaults live, not a profile
+		if name == "okta" {
+			continue
+		}
+		if !setsProfileKey(profiles, name, "aws_saml_url") && !setsProfileKey(profiles, name, "role_arn") {
+			continue
+		}
+		if _, _, err := profiles.GetValue(name, "aws_saml_url"); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setsProfileKey returns whether the profile's section, or that of its
+// source_profile, sets key
+func setsProfileKey(profiles lib.Profiles, profile, key string) bool {
+	if _, ok := profiles[profile][key]; ok {
+		return true
 	}
-	section := credIni.Section(profile)
+	_, ok := profiles[profiles.SourceProfile(profile)][key]
+	return ok
+}
+
+// setCredentialsSection replaces the profile's keys in the credentials file,
+// leaving any others and comments alone
+func setCredentialsSection(f *ini.File, profile string, creds profileCredentials) {
+	section := f.Section(profile)
 	section.Key("aws_access_key_id").SetValue(creds.AccessKeyID)
 	section.Key("aws_secret_access_key").SetValue(creds.SecretAccessKey)
 	section.Key("aws_session_token").SetValue(creds.SessionToken)
 	section.Key("aws_security_token").SetValue(creds.SessionToken)
+	if creds.Expiration.IsZero() {
+		section.DeleteKey(expirationKey)
+	} else {
+		section.Key(expirationKey).SetValue(creds.Expiration.UTC().Format(time.RFC3339))
+	}
+}
 
-	credFile, err := os.OpenFile(credFilePath, os.O_WRONLY, 0600)
+// removeCredentialsSections removes the named sections, and with all every
+// section written by aws-okta
+func removeCredentialsSections(f *ini.File, profiles []string, all bool) {
+	for _, profile := range profiles {
+		if _, err := f.GetSection(profile); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s is not in the credentials file\n", profile)
+			continue
+		}
+		f.DeleteSection(profile)
+	}
+	if all {
+		for _, section := range f.Sections() {
+			if section.HasKey(expirationKey) {
+				f.DeleteSection(section.Name())
+			}
+		}
+	}
+}
+
+// updateCredentialsFile applies update to the credentials file at path and
+// atomically replaces it, holding a lock so that concurrent updates aren't
+// lost. The file is created if it doesn't exist; otherwise its permissions
+// are kept.
+func updateCredentialsFile(path string, update func(*ini.File) error) error {
+	// replace the file a symlink points to, not the symlink
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	path, err := filepath.Abs(path)
 	if err != nil {
 		return err
 	}
-	_, err = credIni.WriteTo(credFile)
+
+	lock, err := filelock.Acquire("credentials "+path, credentialsFileLockTimeout)
+	if xerrors.Is(err, filelock.ErrTimeout) {
+		return fmt.Errorf("waiting for another aws-okta to finish writing %s: %v", path, err)
+	}
 	if err != nil {
+		log.Debugf("Writing %s without a lock: %s", path, err)
+	} else {
+		defer lock.Release()
+	}
+
+	mode := os.FileMode(0600)
+	f := ini.Empty()
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		mode = info.Mode().Perm()
+		if f, err = ini.Load(path); err != nil {
+			return err
+		}
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+	default:
 		return err
 	}
-	err = credFile.Close()
+
+	if err := update(f); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return nil
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := f.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
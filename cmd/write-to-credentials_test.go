@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/aws-okta/internal/filelock"
+	"github.com/segmentio/aws-okta/lib"
+	ini "gopkg.in/ini.v1"
+)
+
+func TestOktaProfiles(t *testing.T) {
+	profiles := lib.Profiles{
+		"okta":     {"aws_saml_url": "home/amazon_aws/xyz/123"},
+		"base":     {"aws_saml_url": "home/amazon_aws/abc/456"},
+		"dev":      {"role_arn": "arn:aws:iam::123456789012:role/dev"},
+		"chained":  {"source_profile": "base"},
+		"prod":     {"role_arn": "arn:aws:iam::123456789012:role/prod", "source_profile": "base"},
+		"personal": {"region": "us-east-1"},
+		"static":   {"source_profile": "personal"},
+	}
+	expected := []string{"base", "chained", "dev", "prod"}
+	if names := oktaProfiles(profiles); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestUpdateCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta-credentials-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filelock.Directory = filepath.Join(dir, "locks")
+	defer func() { filelock.Directory = "" }()
+
+	setKey := func(section, key, value string) func(*ini.File) error {
+		return func(f *ini.File) error {
+			f.Section(section).Key(key).SetValue(value)
+			return nil
+		}
+	}
+	read := func(path string) string {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	t.Run("created when missing", func(t *testing.T) {
+		path := filepath.Join(dir, "new", "credentials")
+		if err := updateCredentialsFile(path, setKey("dev", "aws_access_key_id", "AKID")); err != nil {
+			t.Fatal(err)
+		}
+		if content := read(path); !strings.Contains(content, "[dev]") || !strings.Contains(content, "AKID") {
+			t.Errorf("unexpected content:\n%s", content)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+			t.Errorf("expected a new file to be private, got mode %s", info.Mode())
+		}
+	})
+
+	t.Run("comments and mode kept", func(t *testing.T) {
+		path := filepath.Join(dir, "credentials")
+		existing := "# my static keys\n[personal]\naws_access_key_id = PERSONAL\n"
+		if err := ioutil.WriteFile(path, []byte(existing), 0640); err != nil {
+			t.Fatal(err)
+		}
+		os.Chmod(path, 0640)
+		if err := updateCredentialsFile(path, setKey("dev", "aws_access_key_id", "AKID")); err != nil {
+			t.Fatal(err)
+		}
+		content := read(path)
+		for _, want := range []string{"# my static keys", "PERSONAL", "[dev]"} {
+			if !strings.Contains(content, want) {
+				t.Errorf("expected %q to be kept, got:\n%s", want, content)
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+			t.Errorf("expected the mode to be kept, got %s", info.Mode())
+		}
+	})
+
+	t.Run("shorter content truncates", func(t *testing.T) {
+		path := filepath.Join(dir, "truncated")
+		existing := "[dev]\naws_session_token = " + strings.Repeat("x", 500) + "\n"
+		if err := ioutil.WriteFile(path, []byte(existing), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := updateCredentialsFile(path, setKey("dev", "aws_session_token", "short")); err != nil {
+			t.Fatal(err)
+		}
+		f, err := ini.Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token := f.Section("dev").Key("aws_session_token").String(); token != "short" {
+			t.Errorf("expected the token to be replaced, got %q", token)
+		}
+		if content := read(path); strings.Contains(content, "x") {
+			t.Errorf("expected nothing of the old token to be left, got:\n%s", content)
+		}
+	})
+
+	t.Run("failed update leaves the file alone", func(t *testing.T) {
+		path := filepath.Join(dir, "credentials")
+		before := read(path)
+		err := updateCredentialsFile(path, func(*ini.File) error { return os.ErrInvalid })
+		if err != os.ErrInvalid {
+			t.Errorf("expected the update's error, got %v", err)
+		}
+		if after := read(path); after != before {
+			t.Errorf("expected the file to be unchanged, got:\n%s", after)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+// Package filelock provides advisory locks shared between aws-okta processes,
+// so that only one of them authenticates or writes a cache at a time.
+//
+// Locks are held by the operating system on behalf of an open file, so a lock
+// whose holder dies is released with it rather than going stale. Lock files
+// are left in place, as removing them would let two processes lock different
+// files of the same name.
+package filelock
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// ErrTimeout is returned when a lock couldn't be acquired in time
+var ErrTimeout = errors.New("timed out waiting for lock")
+
+// how often a held lock is retried
+const pollInterval = 100 * time.Millisecond
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Lock is a held lock
+type Lock struct {
+	file *os.File
+}
+
+// Directory, if set, is where lock files are kept instead of
+// ~/.aws-okta/locks, eg in tests
+var Directory string
+
+// Dir returns the directory lock files are kept in
+func Dir() (string, error) {
+	if Directory != "" {
+		return Directory, nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws-okta", "locks"), nil
+}
+
+// Path returns the lock file for name. Names may contain any characters.
+func Path(name string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	// names are cleaned up to be readable, and hashed to stay unique
+	sum := sha256.Sum256([]byte(name))
+	file := fmt.Sprintf("%s-%x.lock", unsafeChars.ReplaceAllString(name, "_"), sum[:4])
+	return filepath.Join(dir, file), nil
+}
+
+// Acquire waits up to timeout for the lock called name. If it is still held
+// by another process, the error wraps ErrTimeout.
+func Acquire(name string, timeout time.Duration) (*Lock, error) {
+	path, err := Path(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, xerrors.Errorf("locking %s: %w", path, err)
+		}
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			holder := readHolder(f)
+			f.Close()
+			return nil, xerrors.Errorf("%s is held by %s: %w", name, holder, ErrTimeout)
+		}
+		if !waiting {
+			log.Infof("Waiting for %s, held by %s", name, readHolder(f))
+			waiting = true
+		}
+		time.Sleep(pollInterval)
+	}
+
+	// record who holds the lock, for anyone waiting on it
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	}
+
+	log.Debugf("Acquired lock %s", name)
+	return &Lock{file: f}, nil
+}
+
+// Release releases the lock
+func (l *Lock) Release() error {
+	if err := unlock(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// readHolder describes the process holding the lock in f, as best it can
+func readHolder(f *os.File) string {
+	content, err := ioutil.ReadFile(f.Name())
+	if err != nil || len(strings.TrimSpace(string(content))) == 0 {
+		return "another aws-okta process"
+	}
+	return "aws-okta process " + strings.TrimSpace(string(content))
+}
@@ -0,0 +1,49 @@
+package filelock
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/xerrors"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelock-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Directory = dir
+	defer func() { Directory = "" }()
+
+	lock, err := Acquire("profile/test", time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("held lock times out", func(t *testing.T) {
+		_, err := Acquire("profile/test", 200*time.Millisecond)
+		assert.True(t, xerrors.Is(err, ErrTimeout), "expected ErrTimeout, got %v", err)
+	})
+
+	t.Run("other names are independent", func(t *testing.T) {
+		other, err := Acquire("profile/other", 200*time.Millisecond)
+		if assert.NoError(t, err) {
+			assert.NoError(t, other.Release())
+		}
+	})
+
+	t.Run("waiter gets the lock once released", func(t *testing.T) {
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			lock.Release()
+		}()
+		waiter, err := Acquire("profile/test", 5*time.Second)
+		if assert.NoError(t, err) {
+			assert.NoError(t, waiter.Release())
+		}
+	})
+}
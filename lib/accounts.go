@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/99designs/keyring"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultAccountKey is the keyring item naming the account used by profiles
+// without okta_account_name, when it isn't the one added without --account
+const DefaultAccountKey = "okta-default-account"
+
+// DefaultAccountName is how the account added without --account is named by
+// the accounts commands
+const DefaultAccountName = "default"
+
+// Account is a set of okta credentials in the keyring
+type Account struct {
+	// Name is the name given to add --account, or DefaultAccountName
+	Name string
+	// Key is the keyring item holding the credentials
+	Key   string
+	Creds OktaCreds
+	// Default is whether profiles without okta_account_name use the account
+	Default bool
+}
+
+// AccountName returns the name of the account stored in the keyring item key
+func AccountName(key string) string {
+	if key == DefaultOktaAccount {
+		return DefaultAccountName
+	}
+	return strings.TrimPrefix(key, DefaultOktaAccount+"-")
+}
+
+// AccountKey returns the keyring item of the account called name by the
+// accounts and logout commands, where "default" is the default account
+func AccountKey(name string) string {
+	if name == DefaultAccountName {
+		return DefaultOktaAccount
+	}
+	return OktaAccountKey(name)
+}
+
+func isAccountKey(key string) bool {
+	return key == DefaultOktaAccount || strings.HasPrefix(key, DefaultOktaAccount+"-")
+}
+
+// ResolveAccount returns the keyring item to use for the account key of a
+// profile, which is the account set with SetDefaultAccount if the profile
+// doesn't name one
+func ResolveAccount(kr keyring.Keyring, key string) string {
+	if key != DefaultOktaAccount {
+		return key
+	}
+	item, err := kr.Get(DefaultAccountKey)
+	if err != nil || len(item.Data) == 0 {
+		return key
+	}
+	log.Debugf("Using default okta account %s", item.Data)
+	return string(item.Data)
+}
+
+// ListAccounts returns the accounts in the keyring, sorted by name
+func ListAccounts(kr keyring.Keyring) ([]Account, error) {
+	keys, err := kr.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	defaultKey := ResolveAccount(kr, DefaultOktaAccount)
+	var accounts []Account
+	for _, key := range keys {
+		if !isAccountKey(key) {
+			continue
+		}
+		account, err := getAccount(kr, key)
+		if err != nil {
+			return nil, err
+		}
+		account.Default = key == defaultKey
+		accounts = append(accounts, account)
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts, nil
+}
+
+// GetAccount returns the account called name
+func GetAccount(kr keyring.Keyring, name string) (Account, error) {
+	key := AccountKey(name)
+	account, err := getAccount(kr, key)
+	if err != nil {
+		return Account{}, err
+	}
+	account.Default = key == ResolveAccount(kr, DefaultOktaAccount)
+	return account, nil
+}
+
+func getAccount(kr keyring.Keyring, key string) (Account, error) {
+	item, err := kr.Get(key)
+	if err == keyring.ErrKeyNotFound {
+		return Account{}, fmt.Errorf("there is no okta account called %s", AccountName(key))
+	}
+	if err != nil {
+		return Account{}, err
+	}
+
+	var creds OktaCreds
+	if err := json.Unmarshal(item.Data, &creds); err != nil {
+		return Account{}, fmt.Errorf("failed to read okta account %s: %v", AccountName(key), err)
+	}
+	return Account{Name: AccountName(key), Key: key, Creds: creds}, nil
+}
+
+// SetDefaultAccount makes profiles without okta_account_name use the account
+// called name
+func SetDefaultAccount(kr keyring.Keyring, name string) error {
+	key := AccountKey(name)
+	if _, err := getAccount(kr, key); err != nil {
+		return err
+	}
+
+	if key == DefaultOktaAccount {
+		err := kr.Remove(DefaultAccountKey)
+		if err == keyring.ErrKeyNotFound {
+			err = nil
+		}
+		return err
+	}
+	return kr.Set(keyring.Item{
+		Key:                         DefaultAccountKey,
+		Data:                        []byte(key),
+		Label:                       "okta default account",
+		KeychainNotTrustApplication: false,
+	})
+}
+
+// RemoveAccount removes the account called name. If it was the default, the
+// account added without --account becomes the default again.
+func RemoveAccount(kr keyring.Keyring, name string) error {
+	account, err := GetAccount(kr, name)
+	if err != nil {
+		return err
+	}
+	if err := kr.Remove(account.Key); err != nil {
+		return err
+	}
+	if account.Default && account.Key != DefaultOktaAccount {
+		return kr.Remove(DefaultAccountKey)
+	}
+	return nil
+}
+
+// RenameAccount renames the account called from to to, keeping it the
+// default if it was
+func RenameAccount(kr keyring.Keyring, from, to string) error {
+	account, err := GetAccount(kr, from)
+	if err != nil {
+		return err
+	}
+	toKey := AccountKey(to)
+	if _, err := kr.Get(toKey); err == nil {
+		return fmt.Errorf("there is already an okta account called %s", to)
+	}
+
+	item, err := kr.Get(account.Key)
+	if err != nil {
+		return err
+	}
+	item.Key = toKey
+	if err := kr.Set(item); err != nil {
+		return err
+	}
+	if err := kr.Remove(account.Key); err != nil {
+		return err
+	}
+
+	if account.Default {
+		return SetDefaultAccount(kr, to)
+	}
+	return nil
+}
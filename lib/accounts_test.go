@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/99designs/keyring"
+)
+
+func setAccount(t *testing.T, kr keyring.Keyring, key string, creds OktaCreds) {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Set(keyring.Item{Key: key, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	kr := keyring.NewArrayKeyring(nil)
+	setAccount(t, kr, DefaultOktaAccount, OktaCreds{Username: "me", Domain: "work.okta.com"})
+	setAccount(t, kr, OktaAccountKey("client"), OktaCreds{Username: "me@client", Domain: "client.okta.com"})
+	kr.Set(keyring.Item{Key: DefaultSessionCookieKey, Data: []byte("cookie")})
+
+	accounts, err := ListAccounts(kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 || accounts[0].Name != "client" || accounts[1].Name != DefaultAccountName {
+		t.Fatalf("unexpected accounts %+v", accounts)
+	}
+	if accounts[0].Default || !accounts[1].Default {
+		t.Errorf("the account added without --account should be the default: %+v", accounts)
+	}
+
+	if err := SetDefaultAccount(kr, "client"); err != nil {
+		t.Fatal(err)
+	}
+	if key := ResolveAccount(kr, DefaultOktaAccount); key != OktaAccountKey("client") {
+		t.Errorf("expected profiles without an account to use client, got %s", key)
+	}
+	if key := ResolveAccount(kr, OktaAccountKey("other")); key != OktaAccountKey("other") {
+		t.Errorf("profiles naming an account should keep it, got %s", key)
+	}
+
+	if err := RenameAccount(kr, "client", "customer"); err != nil {
+		t.Fatal(err)
+	}
+	account, err := GetAccount(kr, "customer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !account.Default || account.Creds.Username != "me@client" {
+		t.Errorf("the renamed account should keep its credentials and stay the default: %+v", account)
+	}
+	if _, err := GetAccount(kr, "client"); err == nil {
+		t.Error("the old name should be gone")
+	}
+	if err := RenameAccount(kr, "customer", DefaultAccountName); err == nil {
+		t.Error("renaming over an existing account should fail")
+	}
+
+	if err := RemoveAccount(kr, "customer"); err != nil {
+		t.Fatal(err)
+	}
+	if key := ResolveAccount(kr, DefaultOktaAccount); key != DefaultOktaAccount {
+		t.Errorf("removing the default should fall back to %s, got %s", DefaultOktaAccount, key)
+	}
+	if accounts, _ := ListAccounts(kr); len(accounts) != 1 || !accounts[0].Default {
+		t.Errorf("unexpected accounts after removal %+v", accounts)
+	}
+}
+
+func TestAccountKey(t *testing.T) {
+	cases := map[string]string{
+		"":                 DefaultOktaAccount,
+		DefaultAccountName: DefaultOktaAccount,
+		"client":           OktaAccountKey("client"),
+	}
+	for name, key := range cases {
+		if k := AccountKey(name); k != key {
+			t.Errorf("%q: expected %s, got %s", name, key, k)
+		}
+	}
+}
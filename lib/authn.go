@@ -0,0 +1,234 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// Statuses of an okta authentication transaction; see
+// https://developer.okta.com/docs/reference/api/authn/#transaction-state
+const (
+	AuthnStatusSuccess         = "SUCCESS"
+	AuthnStatusMFARequired     = "MFA_REQUIRED"
+	AuthnStatusMFAChallenge    = "MFA_CHALLENGE"
+	AuthnStatusMFAEnroll       = "MFA_ENROLL"
+	AuthnStatusPasswordWarn    = "PASSWORD_WARN"
+	AuthnStatusPasswordExpired = "PASSWORD_EXPIRED"
+	AuthnStatusLockedOut       = "LOCKED_OUT"
+	AuthnStatusRecovery        = "RECOVERY"
+	AuthnStatusUnauthenticated = "UNAUTHENTICATED"
+)
+
+// Results of an MFA challenge
+const (
+	FactorResultWaiting   = "WAITING"
+	FactorResultChallenge = "CHALLENGE"
+	FactorResultRejected  = "REJECTED"
+	FactorResultTimeout   = "TIMEOUT"
+	FactorResultCancelled = "CANCELLED"
+)
+
+// how many times the user may try to choose a new password okta accepts
+const passwordChangeAttempts = 3
+
+// LockedOutError is returned when the okta account is locked out
+type LockedOutError struct {
+	Username string
+}
+
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("okta user %s is locked out; unlock it or ask your okta admin to", e.Username)
+}
+
+// MFAEnrollRequiredError is returned when the okta user must enroll an MFA
+// factor before they can log in
+type MFAEnrollRequiredError struct {
+	Username string
+}
+
+func (e *MFAEnrollRequiredError) Error() string {
+	return fmt.Sprintf("okta user %s must enroll an MFA factor; log in to okta in a browser to set one up", e.Username)
+}
+
+// FactorResultError is returned when an MFA challenge is rejected, times out
+// or otherwise fails
+type FactorResultError struct {
+	Provider   string
+	FactorType string
+	Result     string
+}
+
+func (e *FactorResultError) Error() string {
+	factor := e.Provider + " " + e.FactorType
+	switch e.Result {
+	case FactorResultRejected:
+		return fmt.Sprintf("the %s MFA challenge was rejected", factor)
+	case FactorResultTimeout:
+		return fmt.Sprintf("the %s MFA challenge timed out before it was approved", factor)
+	case FactorResultCancelled:
+		return fmt.Sprintf("the %s MFA challenge was cancelled", factor)
+	}
+	return fmt.Sprintf("the %s MFA challenge failed: %s", factor, e.Result)
+}
+
+// AuthnStatusError is returned when authentication stops in a status
+// aws-okta can't go on from, such as RECOVERY or UNAUTHENTICATED
+type AuthnStatusError struct {
+	Username string
+	Status   string
+}
+
+func (e *AuthnStatusError) Error() string {
+	switch e.Status {
+	case AuthnStatusRecovery:
+		return fmt.Sprintf("okta user %s is recovering their account; finish the recovery in a browser", e.Username)
+	case AuthnStatusUnauthenticated:
+		return fmt.Sprintf("okta did not authenticate user %s", e.Username)
+	}
+	return fmt.Sprintf("unexpected okta authentication status %s for user %s", e.Status, e.Username)
+}
+
+// authenticate steps through the okta authentication transaction in
+// o.UserAuth until it succeeds or can't go on
+func (o *OktaClient) authenticate() error {
+	for {
+		status := o.UserAuth.Status
+		log.Debugf("Okta authentication status: %s", status)
+
+		var err error
+		switch status {
+		case AuthnStatusSuccess:
+			if o.UserAuth.SessionToken == "" {
+				return fmt.Errorf("authentication failed for %s", o.Username)
+			}
+			return nil
+		case AuthnStatusMFARequired:
+			if o.NonInteractive && o.MFAConfig.TokenCommand == "" {
+				return &InteractionRequiredError{Reason: "okta requires MFA"}
+			}
+			log.Info("Requesting MFA. Please complete two-factor authentication with your second device")
+			err = o.challengeMFA()
+		case AuthnStatusPasswordWarn:
+			days := o.UserAuth.Embedded.Policy.Expiration.PasswordExpireDays
+			log.Warnf("Your okta password expires in %d day(s); change it soon", days)
+			err = o.skipPasswordWarning()
+		case AuthnStatusPasswordExpired:
+			if o.NonInteractive {
+				return &InteractionRequiredError{Reason: "the okta password has expired and must be changed"}
+			}
+			err = o.changeExpiredPassword()
+		case AuthnStatusLockedOut:
+			return &LockedOutError{Username: o.Username}
+		case AuthnStatusMFAEnroll:
+			return &MFAEnrollRequiredError{Username: o.Username}
+		default:
+			return &AuthnStatusError{Username: o.Username, Status: status}
+		}
+		if err != nil {
+			return err
+		}
+		if o.UserAuth.Status == status {
+			return fmt.Errorf("okta authentication for %s did not get past %s", o.Username, status)
+		}
+	}
+}
+
+// skipPasswordWarning goes on from PASSWORD_WARN without changing the
+// password
+func (o *OktaClient) skipPasswordWarning() error {
+	payload, err := json.Marshal(OktaStateToken{StateToken: o.UserAuth.StateToken})
+	if err != nil {
+		return err
+	}
+	return o.Get("POST", "api/v1/authn/skip", payload, &o.UserAuth, "json")
+}
+
+// changeExpiredPassword asks the user for a new password until okta accepts
+// one
+func (o *OktaClient) changeExpiredPassword() error {
+	fmt.Fprintf(os.Stderr, "Your okta password has expired and must be changed.\n")
+	if rules := passwordRules(o.UserAuth.Embedded.Policy.Complexity); rules != "" {
+		fmt.Fprintf(os.Stderr, "The new password must have %s.\n", rules)
+	}
+
+	for attempt := 1; ; attempt++ {
+		newPassword, err := prompt("New okta password", true)
+		if err != nil {
+			return err
+		}
+		confirm, err := prompt("Confirm new okta password", true)
+		if err != nil {
+			return err
+		}
+
+		if newPassword == "" {
+			err = errors.New("the new password is empty")
+		} else if newPassword != confirm {
+			err = errors.New("the passwords don't match")
+		} else {
+			err = o.changePassword(newPassword)
+		}
+
+		var httpErr *HTTPError
+		rejected := xerrors.As(err, &httpErr) &&
+			(httpErr.StatusCode == http.StatusBadRequest || httpErr.StatusCode == http.StatusForbidden)
+		if err == nil || (httpErr != nil && !rejected) || attempt == passwordChangeAttempts {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "The password was not changed: %s\n", err)
+	}
+}
+
+// changePassword changes the expired password to newPassword, and calls
+// PasswordChanged so that the stored credentials can be updated
+func (o *OktaClient) changePassword(newPassword string) error {
+	payload, err := json.Marshal(OktaChangePassword{
+		StateToken:  o.UserAuth.StateToken,
+		OldPassword: o.Password,
+		NewPassword: newPassword,
+	})
+	if err != nil {
+		return err
+	}
+	if err := o.Get("POST", "api/v1/authn/credentials/change_password", payload, &o.UserAuth, "json"); err != nil {
+		return err
+	}
+
+	log.Infof("Changed the okta password of %s", o.Username)
+	o.Password = newPassword
+	if o.PasswordChanged != nil {
+		if err := o.PasswordChanged(newPassword); err != nil {
+			log.Warnf("Failed to store the new okta password; update it with `aws-okta add`: %s", err)
+		}
+	}
+	return nil
+}
+
+// passwordRules describes the password complexity policy, eg "at least 8
+// characters, 1 number"
+func passwordRules(c OktaUserAuthnPolicyComplexity) string {
+	var rules []string
+	add := func(n int, what string) {
+		if n > 0 {
+			rules = append(rules, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+	if c.MinLength > 0 {
+		rules = append(rules, fmt.Sprintf("at least %d characters", c.MinLength))
+	}
+	add(c.MinLowerCase, "lowercase letter(s)")
+	add(c.MinUpperCase, "uppercase letter(s)")
+	add(c.MinNumber, "number(s)")
+	add(c.MinSymbol, "symbol(s)")
+	if c.ExcludeUsername {
+		rules = append(rules, "no part of your username")
+	}
+	return strings.Join(rules, ", ")
+}
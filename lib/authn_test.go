@@ -0,0 +1,406 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"golang.org/x/xerrors"
+)
+
+// oktaStub serves canned responses for okta API paths, and records the
+// bodies posted to them
+type oktaStub struct {
+	t         *testing.T
+	responses map[string][]interface{}
+	requests  map[string][]map[string]interface{}
+}
+
+func newOktaStub(t *testing.T) *oktaStub {
+	return &oktaStub{
+		t:         t,
+		responses: map[string][]interface{}{},
+		requests:  map[string][]map[string]interface{}{},
+	}
+}
+
+// on adds responses to path, given in turn; the last one is repeated. A
+// response is either a body to encode as json, an int status code, or an
+// OktaError sent with 403 Forbidden.
+func (s *oktaStub) on(path string, responses ...interface{}) {
+	s.responses[path] = append(s.responses[path], responses...)
+}
+
+func (s *oktaStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	s.requests[r.URL.Path] = append(s.requests[r.URL.Path], body)
+
+	responses := s.responses[r.URL.Path]
+	if len(responses) == 0 {
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	response := responses[0]
+	if len(responses) > 1 {
+		s.responses[r.URL.Path] = responses[1:]
+	}
+
+	if oktaErr, ok := response.(OktaError); ok {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(oktaErr)
+		return
+	}
+	if status, ok := response.(int); ok {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(OktaError{ErrorSummary: http.StatusText(status)})
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// client returns an okta client talking to the stub
+func (s *oktaStub) client() (*OktaClient, func()) {
+	srv := httptest.NewServer(s)
+	o, err := NewOktaClient2(OktaCreds{Username: "me", Password: "old", Domain: "example.okta.com"}, "", OktaCookies{}, MFAConfig{})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if o.BaseURL, err = url.Parse(srv.URL); err != nil {
+		s.t.Fatal(err)
+	}
+	return o, srv.Close
+}
+
+func TestAuthenticatePasswordWarn(t *testing.T) {
+	stub := newOktaStub(t)
+	warn := OktaUserAuthn{Status: AuthnStatusPasswordWarn, StateToken: "state"}
+	warn.Embedded.Policy.Expiration.PasswordExpireDays = 3
+	stub.on("/api/v1/authn", warn)
+	stub.on("/api/v1/authn/skip", OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"})
+
+	o, done := stub.client()
+	defer done()
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	if o.UserAuth.SessionToken != "session" {
+		t.Errorf("expected the session token after skipping the warning, got %q", o.UserAuth.SessionToken)
+	}
+	if skips := stub.requests["/api/v1/authn/skip"]; len(skips) != 1 || skips[0]["stateToken"] != "state" {
+		t.Errorf("expected the warning to be skipped with the state token, got %v", skips)
+	}
+}
+
+func TestAuthenticateStatusErrors(t *testing.T) {
+	for _, status := range []string{AuthnStatusLockedOut, AuthnStatusMFAEnroll, AuthnStatusRecovery, AuthnStatusUnauthenticated} {
+		stub := newOktaStub(t)
+		stub.on("/api/v1/authn", OktaUserAuthn{Status: status, StateToken: "state"})
+		o, done := stub.client()
+		err := o.AuthenticateUser()
+		done()
+
+		var lockedOut *LockedOutError
+		var enroll *MFAEnrollRequiredError
+		var statusErr *AuthnStatusError
+		switch status {
+		case AuthnStatusLockedOut:
+			if !xerrors.As(err, &lockedOut) {
+				t.Errorf("%s: expected a LockedOutError, got %v", status, err)
+			}
+		case AuthnStatusMFAEnroll:
+			if !xerrors.As(err, &enroll) {
+				t.Errorf("%s: expected an MFAEnrollRequiredError, got %v", status, err)
+			}
+		default:
+			if !xerrors.As(err, &statusErr) || statusErr.Status != status {
+				t.Errorf("%s: expected an AuthnStatusError, got %v", status, err)
+			}
+		}
+	}
+}
+
+func TestAuthenticatePasswordExpiredNonInteractive(t *testing.T) {
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", OktaUserAuthn{Status: AuthnStatusPasswordExpired, StateToken: "state"})
+	o, done := stub.client()
+	defer done()
+	o.NonInteractive = true
+
+	var interactionErr *InteractionRequiredError
+	if err := o.AuthenticateUser(); !xerrors.As(err, &interactionErr) {
+		t.Errorf("expected an InteractionRequiredError, got %v", err)
+	}
+}
+
+func TestAuthenticatePasswordExpired(t *testing.T) {
+	expired := OktaUserAuthn{Status: AuthnStatusPasswordExpired, StateToken: "state"}
+	expired.Embedded.Policy.Complexity.MinLength = 8
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", expired)
+	stub.on("/api/v1/authn/credentials/change_password", OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"})
+	// the first passwords don't match
+	prompts, restore := stubPrompt(t, "n3w-Passw0rd!", "typo", "n3w-Passw0rd!", "n3w-Passw0rd!")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+
+	kr := keyring.NewArrayKeyring(nil)
+	p := &OktaProvider{Keyring: kr, OktaAccountName: DefaultOktaAccount}
+	creds := OktaCreds{Username: "me", Password: "old", Domain: "example.okta.com"}
+	o.PasswordChanged = func(newPassword string) error {
+		creds.Password = newPassword
+		return p.setOktaCreds(creds)
+	}
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*prompts) != 4 {
+		t.Errorf("expected mismatched passwords to be asked for again, got prompts %q", *prompts)
+	}
+	changes := stub.requests["/api/v1/authn/credentials/change_password"]
+	if len(changes) != 1 || changes[0]["oldPassword"] != "old" || changes[0]["newPassword"] != "n3w-Passw0rd!" || changes[0]["stateToken"] != "state" {
+		t.Errorf("unexpected change_password requests %v", changes)
+	}
+	if o.UserAuth.SessionToken != "session" {
+		t.Errorf("expected the session token after the change, got %q", o.UserAuth.SessionToken)
+	}
+
+	stored, err := p.getOktaCreds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Password != "n3w-Passw0rd!" || stored.Username != "me" {
+		t.Errorf("expected the new password in the keyring, got %+v", stored)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn/credentials/change_password",
+		http.StatusForbidden,
+		OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"},
+	)
+	o, done := stub.client()
+	defer done()
+	o.UserAuth = &OktaUserAuthn{Status: AuthnStatusPasswordExpired, StateToken: "state"}
+	var stored string
+	o.PasswordChanged = func(newPassword string) error {
+		stored = newPassword
+		return nil
+	}
+
+	err := o.changePassword("weak")
+	var httpErr *HTTPError
+	if !xerrors.As(err, &httpErr) || httpErr.StatusCode != http.StatusForbidden || httpErr.Summary == "" {
+		t.Errorf("expected okta's rejection, got %v", err)
+	}
+	if o.Password != "old" || stored != "" {
+		t.Error("a rejected password should not be kept")
+	}
+
+	if err := o.changePassword("n3w-Passw0rd!"); err != nil {
+		t.Fatal(err)
+	}
+	if o.Password != "n3w-Passw0rd!" || stored != "n3w-Passw0rd!" {
+		t.Errorf("expected the new password to be kept and stored, got %q and %q", o.Password, stored)
+	}
+	if err := o.authenticate(); err != nil {
+		t.Errorf("expected authentication to go on after the change, got %v", err)
+	}
+
+	changes := stub.requests["/api/v1/authn/credentials/change_password"]
+	if len(changes) != 2 || changes[1]["oldPassword"] != "old" || changes[1]["newPassword"] != "n3w-Passw0rd!" || changes[1]["stateToken"] != "state" {
+		t.Errorf("unexpected change_password requests %v", changes)
+	}
+}
+
+func TestPasswordRules(t *testing.T) {
+	rules := passwordRules(OktaUserAuthnPolicyComplexity{MinLength: 8, MinNumber: 1, ExcludeUsername: true})
+	if rules != "at least 8 characters, 1 number(s), no part of your username" {
+		t.Errorf("unexpected rules %q", rules)
+	}
+	if rules := passwordRules(OktaUserAuthnPolicyComplexity{}); rules != "" {
+		t.Errorf("expected no rules, got %q", rules)
+	}
+}
+
+// pushChallenge returns an okta push challenge response with the factor
+// result and number challenge answer
+func pushChallenge(result string, answer int) OktaUserAuthn {
+	auth := OktaUserAuthn{Status: AuthnStatusMFAChallenge, StateToken: "state", FactorResult: result}
+	auth.Embedded.Factor = OktaUserAuthnFactor{Id: "push1", Provider: "OKTA", FactorType: "push"}
+	auth.Embedded.Factor.Embedded.Challenge.CorrectAnswer = answer
+	return auth
+}
+
+func mfaRequired(factors ...OktaUserAuthnFactor) OktaUserAuthn {
+	auth := OktaUserAuthn{Status: AuthnStatusMFARequired, StateToken: "state"}
+	auth.Embedded.Factors = factors
+	return auth
+}
+
+func TestAuthenticatePushNumberChallenge(t *testing.T) {
+	defer func(interval time.Duration) { mfaPollInterval = interval }(mfaPollInterval)
+	mfaPollInterval = time.Millisecond
+
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "push1", Provider: "OKTA", FactorType: "push"}))
+	stub.on("/api/v1/authn/factors/push1/verify",
+		pushChallenge(FactorResultWaiting, 0),
+		pushChallenge(FactorResultWaiting, 42),
+		pushChallenge(FactorResultWaiting, 42),
+		OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"},
+	)
+	o, done := stub.client()
+	defer done()
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	if o.UserAuth.SessionToken != "session" {
+		t.Errorf("expected the session token once the push was approved, got %q", o.UserAuth.SessionToken)
+	}
+	if polls := len(stub.requests["/api/v1/authn/factors/push1/verify"]); polls != 4 {
+		t.Errorf("expected okta to be polled until the push was approved, got %d requests", polls)
+	}
+}
+
+func TestAuthenticatePushFailures(t *testing.T) {
+	defer func(interval time.Duration) { mfaPollInterval = interval }(mfaPollInterval)
+	mfaPollInterval = time.Millisecond
+
+	for _, result := range []string{FactorResultRejected, FactorResultTimeout} {
+		stub := newOktaStub(t)
+		stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "push1", Provider: "OKTA", FactorType: "push"}))
+		stub.on("/api/v1/authn/factors/push1/verify",
+			pushChallenge(FactorResultWaiting, 7),
+			pushChallenge(result, 7),
+		)
+		// quit rather than retry
+		_, restore := stubPrompt(t, "q")
+		o, done := stub.client()
+		err := o.AuthenticateUser()
+		done()
+		restore()
+
+		var resultErr *FactorResultError
+		if !xerrors.As(err, &resultErr) || resultErr.Result != result || resultErr.FactorType != "push" {
+			t.Errorf("%s: expected a FactorResultError, got %v", result, err)
+		}
+	}
+}
+
+func TestCheckFactorResultShowsAnswerOnce(t *testing.T) {
+	o := &OktaClient{UserAuth: &OktaUserAuthn{}}
+	shown := false
+
+	*o.UserAuth = pushChallenge(FactorResultWaiting, 0)
+	if err := o.checkFactorResult("OKTA", &shown); err != nil || shown {
+		t.Errorf("nothing should be shown before okta sends the number: %v", err)
+	}
+	*o.UserAuth = pushChallenge(FactorResultWaiting, 91)
+	if err := o.checkFactorResult("OKTA", &shown); err != nil || !shown {
+		t.Errorf("the number should be shown once okta sends it: %v", err)
+	}
+}
+
+// stubPrompt makes prompt return answers in turn, and returns the prompts
+// shown
+func stubPrompt(t *testing.T, answers ...string) (*[]string, func()) {
+	var prompts []string
+	prompt = func(p string, sensitive bool) (string, error) {
+		prompts = append(prompts, p)
+		if len(answers) == 0 {
+			t.Fatalf("unexpected prompt %q", p)
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+	return &prompts, func() { prompt = Prompt }
+}
+
+func TestAuthenticateSentCodeResend(t *testing.T) {
+	defer func(backoff time.Duration) { resendBackoff = backoff }(resendBackoff)
+	resendBackoff = time.Millisecond
+
+	for factorType, how := range sentCodeFactors {
+		stub := newOktaStub(t)
+		stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "f1", Provider: "OKTA", FactorType: factorType}))
+		stub.on("/api/v1/authn/factors/f1/verify",
+			OktaUserAuthn{Status: AuthnStatusMFAChallenge, StateToken: "state"},
+			OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"},
+		)
+		stub.on("/api/v1/authn/factors/f1/verify/resend", OktaUserAuthn{Status: AuthnStatusMFAChallenge, StateToken: "state"})
+		prompts, restore := stubPrompt(t, "", "", "123456")
+		o, done := stub.client()
+
+		if err := o.AuthenticateUser(); err != nil {
+			t.Errorf("%s: %v", factorType, err)
+		}
+		done()
+		restore()
+
+		if resends := len(stub.requests["/api/v1/authn/factors/f1/verify/resend"]); resends != 2 {
+			t.Errorf("%s: expected 2 resends, got %d", factorType, resends)
+		}
+		verifies := stub.requests["/api/v1/authn/factors/f1/verify"]
+		if len(verifies) != 2 || verifies[0]["passCode"] != "" || verifies[1]["passCode"] != "123456" {
+			t.Errorf("%s: expected the code to be sent, then verified; got %v", factorType, verifies)
+		}
+		if len(*prompts) != 3 || !strings.Contains((*prompts)[0], how) {
+			t.Errorf("%s: unexpected prompts %q", factorType, *prompts)
+		}
+	}
+}
+
+func TestAuthenticateSecurityQuestion(t *testing.T) {
+	question := OktaUserAuthnFactor{Id: "q1", Provider: "OKTA", FactorType: "question"}
+	question.Profile.QuestionText = "What is the food you least liked as a child?"
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(question))
+	stub.on("/api/v1/authn/factors/q1/verify", OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"})
+	prompts, restore := stubPrompt(t, "broccoli")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*prompts) != 1 || (*prompts)[0] != question.Profile.QuestionText {
+		t.Errorf("expected the question to be asked, got %q", *prompts)
+	}
+	if verifies := stub.requests["/api/v1/authn/factors/q1/verify"]; len(verifies) != 1 || verifies[0]["answer"] != "broccoli" {
+		t.Errorf("expected the answer to be verified, got %v", verifies)
+	}
+}
+
+func TestGetFactorId(t *testing.T) {
+	supported := []OktaUserAuthnFactor{
+		{Id: "1", Provider: "OKTA", FactorType: "call"},
+		{Id: "2", Provider: "OKTA", FactorType: "email"},
+		{Id: "3", Provider: "OKTA", FactorType: "question"},
+		{Id: "4", Provider: "CUSTOM", FactorType: "token:hotp"},
+		{Id: "5", Provider: "GOOGLE", FactorType: "token:software:totp"},
+		{Id: "7", Provider: "RSA", FactorType: "token"},
+		{Id: "8", Provider: "SYMANTEC", FactorType: "token"},
+		{Id: "9", Provider: "SYMANTEC", FactorType: "push"},
+	}
+	for _, f := range supported {
+		if id, err := GetFactorId(&f); err != nil || id != f.Id {
+			t.Errorf("%s %s: expected id %s, got %q, %v", f.Provider, f.FactorType, f.Id, id, err)
+		}
+	}
+	if _, err := GetFactorId(&OktaUserAuthnFactor{Id: "6", Provider: "OKTA", FactorType: "signed_nonce"}); err == nil {
+		t.Error("expected an unsupported factor to be refused")
+	}
+}
@@ -0,0 +1,278 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/skratchdot/open-golang/open"
+)
+
+// Browsers with built-in support, set with the browser key
+const (
+	// BrowserDefault is the system's default browser
+	BrowserDefault = ""
+	// BrowserFirefoxContainer opens each profile in its own Firefox container,
+	// through the ext+container: URL scheme of the "Open external links in a
+	// container" extension
+	BrowserFirefoxContainer = "firefox-container"
+	// BrowserChromium opens each profile in its own Chromium or Chrome profile
+	BrowserChromium = "chromium"
+)
+
+// ContainerColors are the colors Firefox containers can have
+var ContainerColors = []string{"blue", "turquoise", "green", "yellow", "orange", "red", "pink", "purple"}
+
+// default commands for the built-in browsers, per GOOS
+var browserCommands = map[string]map[string]string{
+	BrowserFirefoxContainer: {
+		"darwin":  "open -a Firefox {{.URL}}",
+		"windows": `"C:\Program Files\Mozilla Firefox\firefox.exe" {{.URL}}`,
+		"":        "firefox {{.URL}}",
+	},
+	BrowserChromium: {
+		"darwin":  `open -na "Google Chrome" --args --profile-directory={{.ProfileDirectory}} {{.URL}}`,
+		"windows": `"C:\Program Files (x86)\Google\Chrome\Application\chrome.exe" --profile-directory={{.ProfileDirectory}} {{.URL}}`,
+		"":        "chromium --profile-directory={{.ProfileDirectory}} {{.URL}}",
+	},
+}
+
+// Browser opens console and Okta URLs for a profile. The zero value opens them
+// in the system's default browser.
+type Browser struct {
+	// Kind is one of the built-in browsers, eg BrowserFirefoxContainer
+	Kind string
+	// Command is a command line whose arguments are templates; see
+	// BrowserTemplate for what they can refer to. The URL is appended if no
+	// argument refers to it.
+	Command string
+	// Profile is the aws-okta profile being opened
+	Profile string
+	// Container, ContainerColor and ContainerIcon describe the Firefox
+	// container to open URLs in
+	Container      string
+	ContainerColor string
+	ContainerIcon  string
+	// ProfileDirectory is the Chromium profile to open URLs in
+	ProfileDirectory string
+}
+
+// BrowserTemplate is what the arguments of a browser command can refer to,
+// eg {{.URL}} or {{.Profile}}
+type BrowserTemplate struct {
+	// URL is the URL to open. For BrowserFirefoxContainer it is the
+	// ext+container: URL; the plain URL is in TargetURL.
+	URL              string
+	TargetURL        string
+	ContainerURL     string
+	Profile          string
+	Container        string
+	ContainerColor   string
+	ContainerIcon    string
+	ProfileDirectory string
+}
+
+// BrowserFromProfile reads the browser settings for profile. browser and
+// browser_command can be set on the profile, its source profile or the okta
+// section; the container and browser profile are the profile's own, and
+// default to its name.
+func BrowserFromProfile(profiles Profiles, profile string) (Browser, error) {
+	b := Browser{Profile: profile}
+	b.Kind, _, _ = profiles.GetValue(profile, "browser")
+	b.Command, _, _ = profiles.GetValue(profile, "browser_command")
+
+	switch b.Kind {
+	case BrowserDefault, BrowserFirefoxContainer, BrowserChromium:
+	default:
+		return b, fmt.Errorf("unknown browser %q; use %s or %s", b.Kind, BrowserFirefoxContainer, BrowserChromium)
+	}
+
+	own := profiles[profile]
+	b.Container = own["browser_container"]
+	b.ContainerColor = own["browser_container_color"]
+	b.ContainerIcon = own["browser_container_icon"]
+	b.ProfileDirectory = own["browser_profile_directory"]
+
+	if b.Container == "" {
+		b.Container = profile
+	}
+	if b.ContainerColor == "" {
+		b.ContainerColor = containerColor(b.Container)
+	} else if !isContainerColor(b.ContainerColor) {
+		return b, fmt.Errorf("unknown container color %q; use one of %s", b.ContainerColor, strings.Join(ContainerColors, ", "))
+	}
+	if b.ProfileDirectory == "" {
+		b.ProfileDirectory = profile
+	}
+
+	return b, nil
+}
+
+// Open opens u in the browser
+func (b Browser) Open(u string) error {
+	args, err := b.Args(u)
+	if err != nil {
+		return err
+	}
+	if args == nil {
+		return open.Run(u)
+	}
+
+	log.Debugf("Opening browser: %q", args)
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run browser command: %v", err)
+	}
+	// the browser may keep running long after we exit
+	return cmd.Process.Release()
+}
+
+// Args returns the command line that opens u, or nil if it should be opened
+// in the system's default browser
+func (b Browser) Args(u string) ([]string, error) {
+	command := b.Command
+	if command == "" {
+		commands, ok := browserCommands[b.Kind]
+		if !ok {
+			return nil, nil
+		}
+		if command, ok = commands[runtime.GOOS]; !ok {
+			command = commands[""]
+		}
+	}
+
+	words, err := splitCommandLine(command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid browser_command: %v", err)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("browser_command is empty")
+	}
+
+	data := b.template(u)
+	args := make([]string, 0, len(words)+1)
+	usesURL := false
+	for _, word := range words {
+		t, err := template.New("browser_command").Option("missingkey=error").Parse(word)
+		if err != nil {
+			return nil, fmt.Errorf("invalid browser_command: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("invalid browser_command: %v", err)
+		}
+		if strings.Contains(word, ".URL") || strings.Contains(word, ".TargetURL") || strings.Contains(word, ".ContainerURL") {
+			usesURL = true
+		}
+		args = append(args, buf.String())
+	}
+	if !usesURL {
+		args = append(args, data.URL)
+	}
+
+	return args, nil
+}
+
+func (b Browser) template(u string) BrowserTemplate {
+	data := BrowserTemplate{
+		URL:              u,
+		TargetURL:        u,
+		ContainerURL:     b.ContainerURL(u),
+		Profile:          b.Profile,
+		Container:        b.Container,
+		ContainerColor:   b.ContainerColor,
+		ContainerIcon:    b.ContainerIcon,
+		ProfileDirectory: b.ProfileDirectory,
+	}
+	if b.Kind == BrowserFirefoxContainer {
+		data.URL = data.ContainerURL
+	}
+	return data
+}
+
+// ContainerURL returns an ext+container: URL that opens u in the browser's
+// Firefox container
+func (b Browser) ContainerURL(u string) string {
+	s := "ext+container:name=" + url.QueryEscape(b.Container)
+	if b.ContainerColor != "" {
+		s += "&color=" + url.QueryEscape(b.ContainerColor)
+	}
+	if b.ContainerIcon != "" {
+		s += "&icon=" + url.QueryEscape(b.ContainerIcon)
+	}
+	return s + "&url=" + url.QueryEscape(u)
+}
+
+// containerColor picks a color for a container, the same one every time
+func containerColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return ContainerColors[h.Sum32()%uint32(len(ContainerColors))]
+}
+
+func isContainerColor(color string) bool {
+	for _, c := range ContainerColors {
+		if c == color {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCommandLine splits s into words like a POSIX shell would, without any
+// expansion. Single and double quotes group words; a backslash escapes the
+// next character outside single quotes, except on Windows where it is kept
+// so paths work unquoted.
+func splitCommandLine(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'' && runtime.GOOS != "windows":
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrowserFromProfile(t *testing.T) {
+	profiles := Profiles{
+		"okta": {"browser": BrowserFirefoxContainer},
+		"dev": {
+			"browser_container":       "Development",
+			"browser_container_color": "green",
+		},
+		"prod": {"source_profile": "dev"},
+		"bad":  {"browser_container_color": "mauve"},
+	}
+
+	b, err := BrowserFromProfile(profiles, "dev")
+	assert.NoError(t, err)
+	assert.Equal(t, BrowserFirefoxContainer, b.Kind)
+	assert.Equal(t, "Development", b.Container)
+	assert.Equal(t, "green", b.ContainerColor)
+
+	// the container is the profile's own, not its source profile's
+	b, err = BrowserFromProfile(profiles, "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "prod", b.Container)
+	assert.Equal(t, "prod", b.ProfileDirectory)
+	assert.Contains(t, ContainerColors, b.ContainerColor)
+
+	_, err = BrowserFromProfile(profiles, "bad")
+	assert.Error(t, err)
+
+	_, err = BrowserFromProfile(Profiles{"x": {"browser": "lynx"}}, "x")
+	assert.Error(t, err)
+}
+
+func TestBrowserArgs(t *testing.T) {
+	const u = "https://signin.aws.amazon.com/federation?Action=login&SigninToken=x"
+
+	args, err := Browser{}.Args(u)
+	assert.NoError(t, err)
+	assert.Nil(t, args, "the default browser needs no command")
+
+	b := Browser{
+		Kind:           BrowserFirefoxContainer,
+		Command:        "firefox --new-tab {{.URL}}",
+		Profile:        "dev",
+		Container:      "dev account",
+		ContainerColor: "red",
+	}
+	args, err = b.Args(u)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"firefox", "--new-tab",
+		"ext+container:name=dev+account&color=red&url=https%3A%2F%2Fsignin.aws.amazon.com%2Ffederation%3FAction%3Dlogin%26SigninToken%3Dx",
+	}, args)
+
+	b = Browser{Kind: BrowserChromium, Command: `"my browser" '--profile-directory={{.ProfileDirectory}}'`, ProfileDirectory: "Profile 2"}
+	args, err = b.Args(u)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"my browser", "--profile-directory=Profile 2", u}, args)
+
+	_, err = Browser{Command: "firefox {{.Nope}}"}.Args(u)
+	assert.Error(t, err)
+
+	_, err = Browser{Command: `firefox "unterminated`}.Args(u)
+	assert.Error(t, err)
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	words, err := splitCommandLine(`open -na "Google Chrome" --args 'a b' c""d`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"open", "-na", "Google Chrome", "--args", "a b", "cd"}, words)
+
+	if runtime.GOOS != "windows" {
+		words, err = splitCommandLine(`a\ b "c\"d"`)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a b", `c"d`}, words)
+	}
+}
@@ -66,6 +66,12 @@ func sourceProfile(p string, from Profiles) string {
 	return p
 }
 
+// SourceProfile returns the profile's source_profile, or the profile itself
+// if it has none
+func (p Profiles) SourceProfile(profile string) string {
+	return sourceProfile(profile, p)
+}
+
 func (p Profiles) GetValue(profile string, config_key string) (string, string, error) {
 	config_value, ok := p[profile][config_key]
 	if ok {
@@ -91,3 +97,41 @@ func (p Profiles) GetValue(profile string, config_key string) (string, string, e
 
 	return "", "", fmt.Errorf("Could not find %s in %s, source profile, or okta", config_key, profile)
 }
+
+// DefaultOktaAccount is the keyring item holding okta credentials for profiles
+// without okta_account_name
+const DefaultOktaAccount = "okta-creds"
+
+// DefaultSessionCookieKey is the keyring item holding the okta session cookie
+// for profiles without okta_session_cookie_key
+const DefaultSessionCookieKey = "okta-session-cookie"
+
+// DeviceTokenKey is the keyring item holding the okta device token cookie,
+// which is shared by all accounts
+const DeviceTokenKey = "okta-device-token-cookie"
+
+// OktaAccountKey returns the keyring item holding the okta credentials for an
+// account added with `aws-okta add --account name`; "" is the default account
+func OktaAccountKey(name string) string {
+	if name == "" {
+		return DefaultOktaAccount
+	}
+	return DefaultOktaAccount + "-" + name
+}
+
+// OktaAccount returns the keyring item holding the okta credentials used by
+// profile
+func (p Profiles) OktaAccount(profile string) string {
+	name, _, _ := p.GetValue(profile, "okta_account_name")
+	return OktaAccountKey(name)
+}
+
+// SessionCookieKey returns the keyring item holding the okta session cookie
+// used by profile
+func (p Profiles) SessionCookieKey(profile string) string {
+	key, _, err := p.GetValue(profile, "okta_session_cookie_key")
+	if err != nil {
+		return DefaultSessionCookieKey
+	}
+	return key
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Okta credentials can be given in the environment instead of the keyring, for
+// CI runners and containers. The password is read from AWS_OKTA_PASSWORD, or
+// from the file descriptor in AWS_OKTA_PASSWORD_FD.
+const (
+	EnvOktaUsername   = "AWS_OKTA_USERNAME"
+	EnvOktaPassword   = "AWS_OKTA_PASSWORD"
+	EnvOktaPasswordFD = "AWS_OKTA_PASSWORD_FD"
+	EnvOktaDomain     = "AWS_OKTA_DOMAIN"
+)
+
+// a password file descriptor can only be read once
+var (
+	passwordFDOnce sync.Once
+	passwordFD     string
+	passwordFDErr  error
+)
+
+// EnvCredentialsSet reports whether okta credentials are given in the
+// environment. If they are, nothing is read from or stored in the keyring.
+func EnvCredentialsSet() bool {
+	return os.Getenv(EnvOktaUsername) != ""
+}
+
+// CredsFromEnv returns the okta credentials given in the environment
+func CredsFromEnv() (OktaCreds, error) {
+	creds := OktaCreds{
+		Username: os.Getenv(EnvOktaUsername),
+		Password: os.Getenv(EnvOktaPassword),
+		Domain:   os.Getenv(EnvOktaDomain),
+	}
+	if creds.Username == "" {
+		return OktaCreds{}, fmt.Errorf("%s is not set", EnvOktaUsername)
+	}
+	if creds.Domain == "" {
+		return OktaCreds{}, fmt.Errorf("%s is set, but %s isn't", EnvOktaUsername, EnvOktaDomain)
+	}
+
+	if fd := os.Getenv(EnvOktaPasswordFD); fd != "" && creds.Password == "" {
+		password, err := readPasswordFD(fd)
+		if err != nil {
+			return OktaCreds{}, err
+		}
+		creds.Password = password
+	}
+	if creds.Password == "" {
+		return OktaCreds{}, fmt.Errorf("%s is set, but neither %s nor %s is", EnvOktaUsername, EnvOktaPassword, EnvOktaPasswordFD)
+	}
+
+	return creds, nil
+}
+
+// readPasswordFD reads the password from the file descriptor fd, the first
+// time it is called
+func readPasswordFD(fd string) (string, error) {
+	passwordFDOnce.Do(func() {
+		n, err := strconv.Atoi(fd)
+		if err != nil || n < 0 {
+			passwordFDErr = fmt.Errorf("invalid %s %q", EnvOktaPasswordFD, fd)
+			return
+		}
+		f := os.NewFile(uintptr(n), "okta password")
+		if f == nil {
+			passwordFDErr = fmt.Errorf("invalid %s %q", EnvOktaPasswordFD, fd)
+			return
+		}
+		defer f.Close()
+
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			passwordFDErr = fmt.Errorf("reading the okta password from fd %d: %v", n, err)
+			return
+		}
+		passwordFD = strings.TrimRight(string(data), "\r\n")
+	})
+	return passwordFD, passwordFDErr
+}
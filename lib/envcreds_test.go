@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestCredsFromEnv(t *testing.T) {
+	defer restoreEnv(EnvOktaUsername, EnvOktaPassword, EnvOktaPasswordFD, EnvOktaDomain)()
+
+	os.Unsetenv(EnvOktaUsername)
+	os.Unsetenv(EnvOktaPasswordFD)
+	if EnvCredentialsSet() {
+		t.Error("credentials should not be set without a username")
+	}
+
+	os.Setenv(EnvOktaUsername, "ci-bot")
+	os.Setenv(EnvOktaPassword, "hunter2")
+	os.Unsetenv(EnvOktaDomain)
+	if !EnvCredentialsSet() {
+		t.Error("credentials should be set with a username")
+	}
+	if _, err := CredsFromEnv(); err == nil {
+		t.Error("a domain should be required")
+	}
+
+	os.Setenv(EnvOktaDomain, "example.okta.com")
+	creds, err := CredsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != "ci-bot" || creds.Password != "hunter2" || creds.Domain != "example.okta.com" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+
+	os.Unsetenv(EnvOktaPassword)
+	if _, err := CredsFromEnv(); err == nil {
+		t.Error("a password should be required")
+	}
+}
+
+func TestCredsFromEnvPasswordFD(t *testing.T) {
+	defer restoreEnv(EnvOktaUsername, EnvOktaPassword, EnvOktaPasswordFD, EnvOktaDomain)()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("from-fd\n"))
+	w.Close()
+	// readPasswordFD closes the fd, which must not be closed again when r is
+	// garbage collected, in case it has been reused by then
+	passwordPipes = append(passwordPipes, r)
+
+	os.Setenv(EnvOktaUsername, "ci-bot")
+	os.Setenv(EnvOktaDomain, "example.okta.com")
+	os.Unsetenv(EnvOktaPassword)
+	os.Setenv(EnvOktaPasswordFD, strconv.Itoa(int(r.Fd())))
+
+	for i := 0; i < 2; i++ {
+		creds, err := CredsFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.Password != "from-fd" {
+			t.Errorf("expected the password from the fd, got %q", creds.Password)
+		}
+	}
+}
+
+// passwordPipes keeps pipes whose fds are closed by readPasswordFD alive
+var passwordPipes []*os.File
+
+// restoreEnv returns a function setting the variables back to their current
+// values
+func restoreEnv(names ...string) func() {
+	saved := map[string]*string{}
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			saved[name] = &value
+		} else {
+			saved[name] = nil
+		}
+	}
+	return func() {
+		for name, value := range saved {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// ParseMFAFactors parses an mfa_factors list, eg "OKTA:push, FIDO:webauthn,
+// GOOGLE:token:software:totp"
+func ParseMFAFactors(s string) []string {
+	var factors []string
+	for _, factor := range strings.Split(s, ",") {
+		if factor = strings.TrimSpace(factor); factor != "" {
+			factors = append(factors, factor)
+		}
+	}
+	return factors
+}
+
+// factorPreference is an entry of mfa_factors: [provider][:factor type][@device],
+// or [provider or factor type]@device
+type factorPreference struct {
+	Provider   string
+	FactorType string
+	// ProviderOrType is set for entries with an @ but no colon, eg
+	// push@Work iPhone, and may be either
+	ProviderOrType string
+	// Device is a factor ID or device name
+	Device string
+	// Bare is set for entries without a colon or @, which may be any of a
+	// provider, factor type, factor ID or device name
+	Bare bool
+}
+
+func parseFactorPreference(s string) factorPreference {
+	var pref factorPreference
+	if i := strings.Index(s, "@"); i >= 0 {
+		pref.Device = strings.TrimSpace(s[i+1:])
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, ":"); i >= 0 {
+		pref.Provider = s[:i]
+		pref.FactorType = s[i+1:]
+	} else if pref.Device == "" {
+		pref.Bare = true
+		pref.Provider = s
+	} else {
+		pref.ProviderOrType = s
+	}
+	return pref
+}
+
+func (p factorPreference) matches(f *OktaUserAuthnFactor) bool {
+	if p.Bare {
+		return strings.EqualFold(f.Provider, p.Provider) ||
+			factorTypeMatches(p.Provider, f.FactorType) ||
+			deviceMatches(p.Provider, f)
+	}
+	if p.ProviderOrType != "" && !strings.EqualFold(f.Provider, p.ProviderOrType) && !factorTypeMatches(p.ProviderOrType, f.FactorType) {
+		return false
+	}
+	return (p.Provider == "" || strings.EqualFold(f.Provider, p.Provider)) &&
+		(p.FactorType == "" || factorTypeMatches(p.FactorType, f.FactorType)) &&
+		(p.Device == "" || deviceMatches(p.Device, f))
+}
+
+// factorTypeMatches returns whether want is the factor type, or a prefix of
+// it, eg "token" for "token:software:totp"
+func factorTypeMatches(want, factorType string) bool {
+	want, factorType = strings.ToLower(want), strings.ToLower(factorType)
+	return want == factorType || strings.HasPrefix(factorType, want+":")
+}
+
+func deviceMatches(device string, f *OktaUserAuthnFactor) bool {
+	name := deviceName(f)
+	return f.Id == device || (name != "" && strings.EqualFold(name, device))
+}
+
+// deviceName returns the name okta gives the factor's device, eg the phone
+// running Okta Verify or the number SMS is sent to
+func deviceName(f *OktaUserAuthnFactor) string {
+	for _, name := range []string{f.Profile.Name, f.Profile.AuthenticatorName, f.Profile.PhoneNumber, f.Profile.Email} {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// factorLabel describes the factor to the user, eg "OKTA push (Work iPhone)"
+func factorLabel(f *OktaUserAuthnFactor) string {
+	label := fmt.Sprintf("%s %s", f.Provider, f.FactorType)
+	if name := deviceName(f); name != "" {
+		label += fmt.Sprintf(" (%s)", name)
+	}
+	return label
+}
+
+// preferredMFADevices returns the supported factors matching mfa_factors, in
+// its order. It returns none if mfa_factors isn't set, or, when the user can
+// choose instead, if none match.
+func (o *OktaClient) preferredMFADevices(factors []OktaUserAuthnFactor) ([]*OktaUserAuthnFactor, error) {
+	if len(o.MFAConfig.Factors) == 0 {
+		return nil, nil
+	}
+
+	var preferred []*OktaUserAuthnFactor
+	added := map[string]bool{}
+	for _, entry := range o.MFAConfig.Factors {
+		pref := parseFactorPreference(entry)
+		for i := range factors {
+			f := &factors[i]
+			if added[f.Id] || !pref.matches(f) {
+				continue
+			}
+			if _, err := GetFactorId(f); err != nil {
+				log.Debugf("Skipping %s from mfa_factors: %s", factorLabel(f), err)
+				continue
+			}
+			preferred = append(preferred, f)
+			added[f.Id] = true
+		}
+	}
+
+	if len(preferred) == 0 {
+		if o.NonInteractive {
+			return nil, &InteractionRequiredError{Reason: "none of the factors in mfa_factors are available"}
+		}
+		log.Warnf("None of the factors in mfa_factors (%s) are available", strings.Join(o.MFAConfig.Factors, ", "))
+		return nil, nil
+	}
+	log.Debugf("MFA factors in order of preference: %v", preferred)
+	return preferred, nil
+}
+
+// isFactorFailure returns whether err means that the factor didn't work, so
+// that another can be tried
+func isFactorFailure(err error) bool {
+	var resultErr *FactorResultError
+	var interactionErr *InteractionRequiredError
+	var httpErr *HTTPError
+	return xerrors.As(err, &resultErr) ||
+		xerrors.As(err, &interactionErr) ||
+		(xerrors.As(err, &httpErr) && httpErr.Code == oktaErrInvalidPasscode)
+}
+
+// promptFactorFailure asks the user whether to try factor again or choose
+// another after it failed with failure, and returns the factor to try
+func promptFactorFailure(factor *OktaUserAuthnFactor, factors []OktaUserAuthnFactor, failure error) (*OktaUserAuthnFactor, error) {
+	fmt.Fprintf(os.Stderr, "%s\n", failure)
+	for {
+		choice, err := prompt(fmt.Sprintf("[r]etry %s, [c]hoose another factor or [q]uit", factorLabel(factor)), false)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(choice) {
+		case "r", "retry":
+			return factor, nil
+		case "c", "choose":
+			return promptMFADevice(factors)
+		case "q", "quit":
+			return nil, failure
+		}
+	}
+}
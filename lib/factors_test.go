@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func testFactors() []OktaUserAuthnFactor {
+	factors := []OktaUserAuthnFactor{
+		{Id: "p1", Provider: "OKTA", FactorType: "push"},
+		{Id: "p2", Provider: "OKTA", FactorType: "push"},
+		{Id: "g1", Provider: "GOOGLE", FactorType: "token:software:totp"},
+		{Id: "w1", Provider: "FIDO", FactorType: "webauthn"},
+		{Id: "s1", Provider: "OKTA", FactorType: "sms"},
+		{Id: "x1", Provider: "OKTA", FactorType: "signed_nonce"},
+	}
+	factors[0].Profile.Name = "Work iPhone"
+	factors[1].Profile.Name = "Personal Pixel"
+	factors[4].Profile.PhoneNumber = "+1 XXX-XXX-1234"
+	return factors
+}
+
+func preferredIDs(t *testing.T, o *OktaClient, factors []OktaUserAuthnFactor) []string {
+	preferred, err := o.preferredMFADevices(factors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, f := range preferred {
+		ids = append(ids, f.Id)
+	}
+	return ids
+}
+
+func TestPreferredMFADevices(t *testing.T) {
+	cases := []struct {
+		factors string
+		ids     []string
+	}{
+		{"OKTA:push@personal pixel, FIDO, token, OKTA:push", []string{"p2", "w1", "g1", "p1"}},
+		{"GOOGLE:token:software:totp, OKTA", []string{"g1", "p1", "p2", "s1"}},
+		{"s1, push", []string{"s1", "p1", "p2"}},
+		{":push@p2, @Work iPhone", []string{"p2", "p1"}},
+		{"push@Work iPhone, OKTA@+1 XXX-XXX-1234, sms@Work iPhone", []string{"p1", "s1"}},
+		{"OKTA:signed_nonce, DUO", nil},
+		{"", nil},
+	}
+	for _, c := range cases {
+		o := &OktaClient{MFAConfig: MFAConfig{Factors: ParseMFAFactors(c.factors)}}
+		if ids := preferredIDs(t, o, testFactors()); !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("%q: expected %v, got %v", c.factors, c.ids, ids)
+		}
+	}
+
+	o := &OktaClient{MFAConfig: MFAConfig{Factors: []string{"DUO"}}, NonInteractive: true}
+	var interactionErr *InteractionRequiredError
+	if _, err := o.preferredMFADevices(testFactors()); !xerrors.As(err, &interactionErr) {
+		t.Errorf("expected an InteractionRequiredError without a matching factor, got %v", err)
+	}
+}
+
+func TestAuthenticateFactorFallback(t *testing.T) {
+	defer func(interval time.Duration) { mfaPollInterval = interval }(mfaPollInterval)
+	mfaPollInterval = time.Millisecond
+
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(
+		OktaUserAuthnFactor{Id: "totp1", Provider: "GOOGLE", FactorType: "token:software:totp"},
+		OktaUserAuthnFactor{Id: "push1", Provider: "OKTA", FactorType: "push"},
+	))
+	stub.on("/api/v1/authn/factors/push1/verify", pushChallenge(FactorResultWaiting, 0), pushChallenge(FactorResultTimeout, 0))
+	stub.on("/api/v1/authn/factors/totp1/verify", OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"})
+	_, restore := stubPrompt(t, "123456")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+	o.MFAConfig.Factors = ParseMFAFactors("OKTA:push, GOOGLE:token:software:totp")
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.requests["/api/v1/authn/factors/push1/verify"]) != 2 {
+		t.Error("expected the push to be tried first")
+	}
+	if verifies := stub.requests["/api/v1/authn/factors/totp1/verify"]; len(verifies) != 1 || verifies[0]["passCode"] != "123456" {
+		t.Errorf("expected the TOTP factor to be tried after the push timed out, got %v", verifies)
+	}
+}
+
+func TestAuthenticateFactorFailureMenu(t *testing.T) {
+	defer func(interval time.Duration) { mfaPollInterval = interval }(mfaPollInterval)
+	mfaPollInterval = time.Millisecond
+
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(
+		OktaUserAuthnFactor{Id: "push1", Provider: "OKTA", FactorType: "push"},
+		OktaUserAuthnFactor{Id: "totp1", Provider: "GOOGLE", FactorType: "token:software:totp"},
+	))
+	stub.on("/api/v1/authn/factors/push1/verify",
+		pushChallenge(FactorResultWaiting, 0),
+		pushChallenge(FactorResultRejected, 0),
+		pushChallenge(FactorResultWaiting, 0),
+		pushChallenge(FactorResultRejected, 0),
+	)
+	stub.on("/api/v1/authn/factors/totp1/verify", OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"})
+	// choose the push, retry it, then choose the TOTP factor
+	prompts, restore := stubPrompt(t, "0", "again", "r", "c", "1", "123456")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*prompts) != 6 {
+		t.Errorf("unexpected prompts %q", *prompts)
+	}
+	if len(stub.requests["/api/v1/authn/factors/push1/verify"]) != 4 {
+		t.Error("expected the push to be sent twice")
+	}
+	if len(stub.requests["/api/v1/authn/factors/totp1/verify"]) != 1 {
+		t.Error("expected the TOTP factor to be verified")
+	}
+
+	// quitting returns the failure
+	stub = newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "push1", Provider: "OKTA", FactorType: "push"}))
+	stub.on("/api/v1/authn/factors/push1/verify", pushChallenge(FactorResultRejected, 0))
+	_, restore = stubPrompt(t, "q")
+	defer restore()
+	o, done = stub.client()
+	defer done()
+
+	var resultErr *FactorResultError
+	if err := o.AuthenticateUser(); !xerrors.As(err, &resultErr) || resultErr.Result != FactorResultRejected {
+		t.Errorf("expected the rejection, got %v", err)
+	}
+}
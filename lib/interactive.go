@@ -0,0 +1,15 @@
+package lib
+
+import "fmt"
+
+// InteractionRequiredError is returned in non-interactive mode when getting
+// credentials can't go on without the user, eg to complete MFA or to choose a
+// role
+type InteractionRequiredError struct {
+	// Reason says what the user is needed for
+	Reason string
+}
+
+func (e *InteractionRequiredError) Error() string {
+	return fmt.Sprintf("user interaction required: %s", e.Reason)
+}
@@ -4,13 +4,36 @@ import (
 	"os"
 
 	"github.com/99designs/keyring"
+	log "github.com/sirupsen/logrus"
 )
 
 func keyringPrompt(prompt string) (string, error) {
 	return PromptWithOutput(prompt, true, os.Stderr)
 }
 
+// nonInteractiveKeyringPrompt fails rather than ask for the passphrase
+func nonInteractiveKeyringPrompt(prompt string) (string, error) {
+	return "", &InteractionRequiredError{Reason: "the keyring needs its passphrase"}
+}
+
 func OpenKeyring(allowedBackends []keyring.BackendType) (kr keyring.Keyring, err error) {
+	return openKeyring(allowedBackends, keyringPrompt)
+}
+
+// OpenKeyringNonInteractive opens the keyring like OpenKeyring, but fails with
+// an InteractionRequiredError if the keyring needs a passphrase
+func OpenKeyringNonInteractive(allowedBackends []keyring.BackendType) (kr keyring.Keyring, err error) {
+	return openKeyring(allowedBackends, nonInteractiveKeyringPrompt)
+}
+
+func openKeyring(allowedBackends []keyring.BackendType, prompt keyring.PromptFunc) (kr keyring.Keyring, err error) {
+	// with okta credentials in the environment, sessions are only kept in
+	// memory, for the life of the process
+	if EnvCredentialsSet() {
+		log.Debugf("Okta credentials are set in the environment, using an in-memory keyring")
+		return keyring.NewArrayKeyring(nil), nil
+	}
+
 	kr, err = keyring.Open(keyring.Config{
 		AllowedBackends:          allowedBackends,
 		KeychainTrustApplication: true,
@@ -18,7 +41,7 @@ func OpenKeyring(allowedBackends []keyring.BackendType) (kr keyring.Keyring, err
 		ServiceName:             "aws-okta-login",
 		LibSecretCollectionName: "awsvault",
 		FileDir:                 "~/.aws-okta/",
-		FilePasswordFunc:        keyringPrompt,
+		FilePasswordFunc:        prompt,
 	})
 
 	return
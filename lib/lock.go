@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/segmentio/aws-okta/internal/filelock"
+	log "github.com/sirupsen/logrus"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// acquireLock takes the cross-process lock called name and returns a function
+// releasing it. If locks aren't available, eg on a filesystem without lock
+// support, we carry on without.
+func acquireLock(name string, timeout time.Duration) (func(), error) {
+	lock, err := filelock.Acquire(name, timeout)
+	if xerrors.Is(err, filelock.ErrTimeout) {
+		return nil, xerrors.Errorf("waiting for another aws-okta to finish authenticating: %w", err)
+	}
+	if err != nil {
+		log.Debugf("Continuing without lock %s: %s", name, err)
+		return func() {}, nil
+	}
+
+	return func() {
+		if err := lock.Release(); err != nil {
+			log.Debugf("Failed releasing lock %s: %s", name, err)
+		}
+	}, nil
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -23,6 +25,9 @@ import (
 	"github.com/segmentio/aws-okta/lib/mfa"
 	"github.com/segmentio/aws-okta/lib/saml"
 	log "github.com/sirupsen/logrus"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
 )
 
 const (
@@ -37,6 +42,12 @@ const (
 	Timeout = time.Duration(60 * time.Second)
 )
 
+// prompt is Prompt, replaced in tests
+var prompt = Prompt
+
+// how often to check whether an MFA push has been approved
+var mfaPollInterval = 2 * time.Second
+
 type OktaClient struct {
 	// Organization will be deprecated in the future
 	Organization    string
@@ -53,12 +64,45 @@ type OktaClient struct {
 	BaseURL         *url.URL
 	Domain          string
 	MFAConfig       MFAConfig
+	// NonInteractive makes authentication fail with an
+	// InteractionRequiredError rather than prompt the user
+	NonInteractive bool
+	// PasswordChanged, if set, is called with the new password after the
+	// user changes an expired one
+	PasswordChanged func(newPassword string) error
+
+	// how many times MFAConfig.TokenCommand has been run, and whether the
+	// passcode being verified came from it
+	tokenCommandAttempts int
+	passcodeFromCommand  bool
 }
 
 type MFAConfig struct {
-	Provider   string // Which MFA provider to use when presented with an MFA challenge
-	FactorType string // Which of the factor types of the MFA provider to use
-	DuoDevice  string // Which DUO device to use for DUO MFA
+	Provider   string `json:",omitempty"` // Which MFA provider to use when presented with an MFA challenge
+	FactorType string `json:",omitempty"` // Which of the factor types of the MFA provider to use
+	DuoDevice  string `json:",omitempty"` // Which DUO device to use for DUO MFA
+	// TokenCommand is run with the shell to get passcodes for token factors
+	TokenCommand string `json:",omitempty"`
+	// Factors are the factors to try in order, from mfa_factors
+	Factors []string `json:",omitempty"`
+}
+
+// WithDefaults returns the config with the provider, factor type, token
+// command and factors taken from defaults if they aren't set
+func (c MFAConfig) WithDefaults(defaults MFAConfig) MFAConfig {
+	if c.Provider == "" {
+		c.Provider = defaults.Provider
+	}
+	if c.FactorType == "" {
+		c.FactorType = defaults.FactorType
+	}
+	if c.TokenCommand == "" {
+		c.TokenCommand = defaults.TokenCommand
+	}
+	if len(c.Factors) == 0 {
+		c.Factors = defaults.Factors
+	}
+	return c
 }
 
 type SAMLAssertion struct {
@@ -72,6 +116,8 @@ type OktaCreds struct {
 	Username     string
 	Password     string
 	Domain       string
+	// MFA is the MFA config to use with this account when none is given
+	MFA MFAConfig
 }
 
 type OktaCookies struct {
@@ -92,6 +138,8 @@ func (c *OktaCreds) Validate(mfaConfig MFAConfig) error {
 		return err
 	}
 
+	// the user may have changed an expired password
+	c.Password = o.Password
 	return nil
 }
 
@@ -188,25 +236,14 @@ func (o *OktaClient) AuthenticateUser() error {
 	log.Debug("Step: 1")
 	err = o.Get("POST", "api/v1/authn", payload, &oktaUserAuthn, "json")
 	if err != nil {
-		return fmt.Errorf("Failed to authenticate with okta. If your credentials have changed, use 'aws-okta add': %#v", err)
+		return fmt.Errorf("Failed to authenticate with okta. If your credentials have changed, use 'aws-okta add': %s", err)
 	}
 
 	o.UserAuth = &oktaUserAuthn
 
-	// Step 2 : Challenge MFA if needed
+	// Step 2 : Challenge MFA, handle password expiry etc until done
 	log.Debug("Step: 2")
-	if o.UserAuth.Status == "MFA_REQUIRED" {
-		log.Info("Requesting MFA. Please complete two-factor authentication with your second device")
-		if err = o.challengeMFA(); err != nil {
-			return err
-		}
-	}
-
-	if o.UserAuth.SessionToken == "" {
-		return fmt.Errorf("authentication failed for %s", o.Username)
-	}
-
-	return nil
+	return o.authenticate()
 }
 
 func (o *OktaClient) AuthenticateProfile(profileARN string, duration time.Duration) (sts.Credentials, string, error) {
@@ -220,31 +257,61 @@ func (o *OktaClient) AuthenticateProfileWithRegion(profileARN string, duration t
 }
 
 func (o *OktaClient) AuthenticateProfile3(profileARN string, duration time.Duration, region string) (sts.Credentials, OktaCookies, error) {
+	assertion, err := o.GetSAMLAssertion()
+	if err != nil {
+		return sts.Credentials{}, OktaCookies{}, err
+	}
+
+	creds, err := AssumeRoleWithSAML(assertion, profileARN, duration, region)
+	if err != nil {
+		return sts.Credentials{}, OktaCookies{}, err
+	}
+
+	return creds, o.Cookies(), nil
+}
 
+// GetSAMLAssertion gets a SAML assertion for the AWS app, reusing the okta
+// session if possible and authenticating otherwise
+func (o *OktaClient) GetSAMLAssertion() (SAMLAssertion, error) {
 	// Attempt to reuse session cookie
 	var assertion SAMLAssertion
-	var oc OktaCookies
 
 	err := o.Get("GET", o.OktaAwsSAMLUrl, nil, &assertion, "saml")
 	if err != nil {
 		log.Debug("Failed to reuse session token, starting flow from start")
 
 		if err := o.AuthenticateUser(); err != nil {
-			return sts.Credentials{}, oc, err
+			return SAMLAssertion{}, err
 		}
 
 		// Step 3 : Get SAML Assertion and retrieve IAM Roles
 		log.Debug("Step: 3")
 		if err = o.Get("GET", o.OktaAwsSAMLUrl+"?onetimetoken="+o.UserAuth.SessionToken,
 			nil, &assertion, "saml"); err != nil {
-			return sts.Credentials{}, oc, err
+			return SAMLAssertion{}, err
 		}
 	}
 
+	return assertion, nil
+}
+
+// AssumeRoleWithSAML exchanges a SAML assertion for credentials of the role
+// matching profileARN
+func AssumeRoleWithSAML(assertion SAMLAssertion, profileARN string, duration time.Duration, region string) (sts.Credentials, error) {
 	principal, role, err := GetRoleFromSAML(assertion.Resp, profileARN)
 	if err != nil {
-		return sts.Credentials{}, oc, err
+		return sts.Credentials{}, err
+	}
+	return assumeRoleWithSAML(assertion, principal, role, duration, region)
+}
+
+func assumeRoleWithSAML(assertion SAMLAssertion, principal, role string, duration time.Duration, region string) (sts.Credentials, error) {
+	// STS has to be called in the role's partition, eg GovCloud
+	partition, err := PartitionFromARN(role)
+	if err != nil {
+		return sts.Credentials{}, err
 	}
+	region = partition.STSRegion(region)
 
 	// Step 4 : Assume Role with SAML
 	log.Debug("Step 4: Assume Role with SAML")
@@ -272,9 +339,15 @@ func (o *OktaClient) AuthenticateProfile3(profileARN string, duration time.Durat
 	if err != nil {
 		log.WithField("role", role).Errorf(
 			"error assuming role with SAML: %s", err.Error())
-		return sts.Credentials{}, oc, err
+		return sts.Credentials{}, err
 	}
 
+	return *samlResp.Credentials, nil
+}
+
+// Cookies returns the okta session and device token cookies, for reuse
+func (o *OktaClient) Cookies() OktaCookies {
+	var oc OktaCookies
 	cookies := o.CookieJar.Cookies(o.BaseURL)
 	for _, cookie := range cookies {
 		if cookie.Name == "sid" {
@@ -284,8 +357,7 @@ func (o *OktaClient) AuthenticateProfile3(profileARN string, duration time.Durat
 			oc.DeviceToken = cookie.Value
 		}
 	}
-
-	return *samlResp.Credentials, oc, nil
+	return oc
 }
 
 func selectMFADeviceFromConfig(o *OktaClient) (*OktaUserAuthnFactor, error) {
@@ -322,11 +394,19 @@ func (o *OktaClient) selectMFADevice() (*OktaUserAuthnFactor, error) {
 		return factor, nil
 	}
 
+	if o.NonInteractive {
+		return nil, &InteractionRequiredError{Reason: "an MFA factor must be chosen; set mfa_factors, or mfa_provider and mfa_factor_type"}
+	}
+	return promptMFADevice(factors)
+}
+
+// promptMFADevice asks the user which of factors to use
+func promptMFADevice(factors []OktaUserAuthnFactor) (*OktaUserAuthnFactor, error) {
 	log.Info("Select a MFA from the following list")
 	for i, f := range factors {
-		log.Infof("%d: %s (%s)", i, f.Provider, f.FactorType)
+		log.Infof("%d: %s", i, factorLabel(&f))
 	}
-	i, err := Prompt("Select MFA method", false)
+	i, err := prompt("Select MFA method", false)
 	if i == "" {
 		return nil, errors.New("Invalid selection - Please use an option that is listed")
 	}
@@ -337,40 +417,39 @@ func (o *OktaClient) selectMFADevice() (*OktaUserAuthnFactor, error) {
 	if err != nil {
 		return nil, err
 	}
-	if factorIdx > (len(factors) - 1) {
+	if factorIdx < 0 || factorIdx > (len(factors)-1) {
 		return nil, errors.New("Invalid selection - Please use an option that is listed")
 	}
 	return &factors[factorIdx], nil
 }
 
-func (o *OktaClient) preChallenge(oktaFactorId, oktaFactorType string) ([]byte, error) {
+func (o *OktaClient) preChallenge(factor *OktaUserAuthnFactor) ([]byte, error) {
 	var mfaCode string
+	var answer string
 	var err error
 
-	//Software and Hardware based OTP Tokens
-	if strings.Contains(oktaFactorType, "token") {
-		log.Debug("Token MFA")
-		mfaCode, err = Prompt("Enter MFA Code", false)
+	if how, ok := sentCodeFactors[factor.FactorType]; ok {
+		// SMS, voice call and email: okta sends a code to type in
+		log.Debugf("%s MFA", how)
+		mfaCode, err = o.promptSentCode(factor.Id, how)
 		if err != nil {
 			return nil, err
 		}
-	} else if strings.Contains(oktaFactorType, "sms") {
-		log.Debug("SMS MFA")
-		payload, err := json.Marshal(OktaStateToken{
-			StateToken: o.UserAuth.StateToken,
-		})
+	} else if factor.FactorType == "question" {
+		log.Debug("Security question MFA")
+		answer, err = prompt(factor.Profile.QuestionText, true)
 		if err != nil {
 			return nil, err
 		}
-		var sms interface{}
-		log.Debug("Requesting SMS Code")
-		err = o.Get("POST", "api/v1/authn/factors/"+oktaFactorId+"/verify",
-			payload, &sms, "json",
-		)
-		if err != nil {
-			return nil, err
+	} else if strings.Contains(factor.FactorType, "token") {
+		//Software and Hardware based OTP Tokens
+		log.Debug("Token MFA")
+		if factor.Provider == "RSA" {
+			// the PIN followed by the tokencode
+			mfaCode, err = o.tokenCode("Enter RSA SecurID passcode", true)
+		} else {
+			mfaCode, err = o.tokenCode("Enter MFA Code", false)
 		}
-		mfaCode, err = Prompt("Enter MFA Code from SMS", false)
 		if err != nil {
 			return nil, err
 		}
@@ -379,6 +458,7 @@ func (o *OktaClient) preChallenge(oktaFactorId, oktaFactorType string) ([]byte,
 	payload, err := json.Marshal(OktaStateToken{
 		StateToken: o.UserAuth.StateToken,
 		PassCode:   mfaCode,
+		Answer:     answer,
 	})
 	if err != nil {
 		return nil, err
@@ -386,9 +466,65 @@ func (o *OktaClient) preChallenge(oktaFactorId, oktaFactorType string) ([]byte,
 	return payload, nil
 }
 
+// sentCodeFactors are the factor types okta sends a code for, and how it's
+// sent
+var sentCodeFactors = map[string]string{
+	"sms":   "SMS",
+	"call":  "voice call",
+	"email": "email",
+}
+
+// how long to wait before the first resend of an MFA code, doubled for each
+// one after; okta refuses resends within 30 seconds
+var resendBackoff = 30 * time.Second
+
+// how many times an MFA code can be resent
+const maxResends = 3
+
+// promptSentCode has okta send a code, and asks the user for it. Entering
+// nothing resends the code, backing off between resends.
+func (o *OktaClient) promptSentCode(oktaFactorId, how string) (string, error) {
+	payload, err := json.Marshal(OktaStateToken{
+		StateToken: o.UserAuth.StateToken,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Debugf("Requesting %s code", how)
+	path := "api/v1/authn/factors/" + oktaFactorId + "/verify"
+	if err := o.Get("POST", path, payload, nil, "json"); err != nil {
+		return "", err
+	}
+
+	backoff := resendBackoff
+	sent := time.Now()
+	for resends := 0; ; resends++ {
+		if resends < maxResends {
+			mfaCode, err := prompt(fmt.Sprintf("Enter MFA Code from %s (or nothing to resend it)", how), false)
+			if err != nil || mfaCode != "" {
+				return mfaCode, err
+			}
+		} else {
+			return prompt(fmt.Sprintf("Enter MFA Code from %s", how), false)
+		}
+
+		if wait := time.Until(sent.Add(backoff)); wait > 0 {
+			log.Infof("Resending the code in %d seconds", int(wait.Seconds()+0.5))
+			time.Sleep(wait)
+		}
+		log.Infof("Resending the %s code", how)
+		if err := o.Get("POST", path+"/resend", payload, nil, "json"); err != nil {
+			return "", err
+		}
+		sent = time.Now()
+		backoff *= 2
+	}
+}
+
 func (o *OktaClient) postChallenge(payload []byte, oktaFactorProvider string, oktaFactorId string) error {
 	//Initiate Push Notification
-	if o.UserAuth.Status == "MFA_CHALLENGE" {
+	if o.UserAuth.Status == AuthnStatusMFAChallenge {
 		f := o.UserAuth.Embedded.Factor
 		errChan := make(chan error, 1)
 
@@ -442,9 +578,19 @@ func (o *OktaClient) postChallenge(payload []byte, oktaFactorProvider string, ok
 			if err != nil {
 				return err
 			}
+		} else if oktaFactorProvider == "RSA" {
+			if err := o.challengeRSA(payload, oktaFactorId); err != nil {
+				return err
+			}
 		}
 		// Poll Okta until authentication has been completed
-		for o.UserAuth.Status != "SUCCESS" {
+		answerShown := false
+		for o.UserAuth.Status == AuthnStatusMFAChallenge {
+			if err := o.checkFactorResult(oktaFactorProvider, &answerShown); err != nil {
+				return err
+			}
+			time.Sleep(mfaPollInterval)
+
 			select {
 			case duoErr := <-errChan:
 				log.Printf("Err: %s", duoErr)
@@ -459,23 +605,79 @@ func (o *OktaClient) postChallenge(payload []byte, oktaFactorProvider string, ok
 					return fmt.Errorf("Failed authn verification for okta. Err: %s", err)
 				}
 			}
-			time.Sleep(2 * time.Second)
 		}
 	}
 	return nil
 }
 
-func (o *OktaClient) challengeMFA() (err error) {
+// checkFactorResult fails if the MFA challenge being polled was rejected or
+// timed out. While it's waiting, the number to choose in Okta Verify is shown
+// once okta sends it.
+func (o *OktaClient) checkFactorResult(provider string, answerShown *bool) error {
+	switch result := o.UserAuth.FactorResult; result {
+	case "", FactorResultWaiting, FactorResultChallenge:
+		answer := o.UserAuth.Embedded.Factor.Embedded.Challenge.CorrectAnswer
+		if answer != 0 && !*answerShown {
+			fmt.Fprintf(os.Stderr, "\n    Okta Verify: tap %d on your device to finish logging in\n\n", answer)
+			*answerShown = true
+		}
+		return nil
+	default:
+		return &FactorResultError{
+			Provider:   provider,
+			FactorType: o.UserAuth.Embedded.Factor.FactorType,
+			Result:     result,
+		}
+	}
+}
+
+func (o *OktaClient) challengeMFA() error {
+	factors := o.UserAuth.Embedded.Factors
+	candidates, err := o.preferredMFADevices(factors)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		factor, err := o.selectMFADevice()
+		if err != nil {
+			log.Debug("Failed to select MFA device")
+			return err
+		}
+		candidates = []*OktaUserAuthnFactor{factor}
+	}
+
+	for {
+		factor := candidates[0]
+		candidates = candidates[1:]
+		err := o.challengeMFADevice(factor)
+		if err == nil || !isFactorFailure(err) {
+			return err
+		}
+
+		// fall back to the next preferred factor, or ask the user
+		if len(candidates) > 0 {
+			log.Warnf("%s; trying %s instead", err, factorLabel(candidates[0]))
+			continue
+		}
+		if o.NonInteractive {
+			return err
+		}
+		next, err := promptFactorFailure(factor, factors, err)
+		if err != nil {
+			return err
+		}
+		candidates = []*OktaUserAuthnFactor{next}
+	}
+}
+
+// challengeMFADevice verifies the factor, prompting the user or waiting for
+// them as needed
+func (o *OktaClient) challengeMFADevice(factor *OktaUserAuthnFactor) (err error) {
 	var oktaFactorProvider string
 	var oktaFactorId string
 	var payload []byte
 	var oktaFactorType string
 
-	factor, err := o.selectMFADevice()
-	if err != nil {
-		log.Debug("Failed to select MFA device")
-		return
-	}
 	oktaFactorProvider = factor.Provider
 	if oktaFactorProvider == "" {
 		return
@@ -492,11 +694,32 @@ func (o *OktaClient) challengeMFA() (err error) {
 	log.Debugf("Okta Factor ID: %s", oktaFactorId)
 	log.Debugf("Okta Factor Type: %s", oktaFactorType)
 
-	payload, err = o.preChallenge(oktaFactorId, oktaFactorType)
+	// forget the result of any factor tried before
+	o.UserAuth.FactorResult = ""
+	o.UserAuth.FactorResultMessage = ""
+	o.UserAuth.Embedded.Factor = OktaUserAuthnFactor{}
+
+	// without the user, only passcodes from mfa_token_command can be given
+	if o.NonInteractive && !strings.HasPrefix(oktaFactorType, "token") {
+		return &InteractionRequiredError{Reason: fmt.Sprintf("the %s %s MFA factor needs the user", oktaFactorProvider, oktaFactorType)}
+	}
+
+	payload, err = o.preChallenge(factor)
+	if err != nil {
+		return
+	}
 
 	err = o.Get("POST", "api/v1/authn/factors/"+oktaFactorId+"/verify?rememberDevice=true",
 		payload, &o.UserAuth, "json",
 	)
+	for o.retryTokenCommand(err) {
+		if payload, err = o.preChallenge(factor); err != nil {
+			return
+		}
+		err = o.Get("POST", "api/v1/authn/factors/"+oktaFactorId+"/verify?rememberDevice=true",
+			payload, &o.UserAuth, "json",
+		)
+	}
 	if err != nil {
 		return
 	}
@@ -514,7 +737,7 @@ func GetFactorId(f *OktaUserAuthnFactor) (id string, err error) {
 	case "web":
 		id = f.Id
 	case "token":
-		if f.Provider == "SYMANTEC" {
+		if f.Provider == "SYMANTEC" || f.Provider == "RSA" {
 			id = f.Id
 		} else {
 			err = fmt.Errorf("provider %s with factor token not supported", f.Provider)
@@ -523,12 +746,15 @@ func GetFactorId(f *OktaUserAuthnFactor) (id string, err error) {
 		id = f.Id
 	case "token:hardware":
 		id = f.Id
-	case "sms":
+	case "token:hotp":
+		// includes custom TOTP factors
+		id = f.Id
+	case "sms", "call", "email", "question":
 		id = f.Id
 	case "u2f", "webauthn":
 		id = f.Id
 	case "push":
-		if f.Provider == "OKTA" || f.Provider == "DUO" {
+		if f.Provider == "OKTA" || f.Provider == "DUO" || f.Provider == "SYMANTEC" {
 			id = f.Id
 		} else {
 			err = fmt.Errorf("provider %s with factor push not supported", f.Provider)
@@ -592,8 +818,12 @@ func (o *OktaClient) Get(method string, path string, data []byte, recv interface
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		err = fmt.Errorf("%s %v: %s", method, url, res.Status)
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		httpErr := &HTTPError{Method: method, URL: url.String(), StatusCode: res.StatusCode, Status: res.Status}
+		if format == "json" {
+			httpErr.Code, httpErr.Summary = oktaError(res.Body)
+		}
+		err = httpErr
 	} else if recv != nil {
 		switch format {
 		case "json":
@@ -613,6 +843,55 @@ func (o *OktaClient) Get(method string, path string, data []byte, recv interface
 	return
 }
 
+// HTTPError is returned by OktaClient.Get when okta doesn't respond with
+// success
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	// Code and Summary are okta's error code and description, if it gave
+	// them
+	Code    string
+	Summary string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Summary != "" {
+		return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Status, e.Summary)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+}
+
+// oktaError returns the code, and the summary and causes, of an okta API
+// error response, eg "Password requirements were not met: Password
+// requirements: at least 8 characters."
+func oktaError(body io.Reader) (string, string) {
+	var oktaErr OktaError
+	if err := json.NewDecoder(body).Decode(&oktaErr); err != nil {
+		return "", ""
+	}
+	summary := oktaErr.ErrorSummary
+	for _, cause := range oktaErr.ErrorCauses {
+		if cause.ErrorSummary != "" && cause.ErrorSummary != summary {
+			summary += ": " + cause.ErrorSummary
+		}
+	}
+	return oktaErr.ErrorCode, summary
+}
+
+// CloseSession revokes the okta session in the client's cookies, so that it
+// can't be used again. A session that has already expired isn't an error.
+func (o *OktaClient) CloseSession() error {
+	err := o.Get("DELETE", "api/v1/sessions/me", nil, nil, "json")
+	var httpErr *HTTPError
+	if xerrors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+		log.Debugf("okta session had already expired")
+		return nil
+	}
+	return err
+}
+
 type OktaProvider struct {
 	Keyring         keyring.Keyring
 	ProfileARN      string
@@ -624,23 +903,62 @@ type OktaProvider struct {
 	OktaAccountName      string
 	MFAConfig            MFAConfig
 	AwsRegion            string
+	// AssertionCache, if set, is used to share SAML assertions between
+	// providers in the same process
+	AssertionCache *SAMLAssertionCache
+	// NonInteractive makes Retrieve fail with an InteractionRequiredError
+	// rather than prompt the user
+	NonInteractive bool
+	// LockTimeout is how long to wait for another process authenticating
+	// with the same okta account; DefaultLockTimeout if zero
+	LockTimeout time.Duration
+
+	// the role assumed by Retrieve
+	roleARN string
 }
 
 func (p *OktaProvider) Retrieve() (sts.Credentials, string, error) {
-	log.Debugf("Using okta provider (%s)", p.OktaAccountName)
-	item, err := p.Keyring.Get(p.OktaAccountName)
-	if err == keyring.ErrKeyNotFound {
-		return sts.Credentials{}, "", errors.New("Okta credentials are not in your keyring.  Please make sure you have added okta credentials with `aws-okta add`")
+	assertion, username, err := p.GetSAMLAssertion()
+	if err != nil {
+		return sts.Credentials{}, "", err
 	}
+
+	creds, err := p.assumeRole(assertion)
 	if err != nil {
-		log.Debugf("Couldnt get okta creds from keyring: %s", err)
 		return sts.Credentials{}, "", err
 	}
+	return creds, username, nil
+}
 
-	var oktaCreds OktaCreds
-	if err = json.Unmarshal(item.Data, &oktaCreds); err != nil {
-		return sts.Credentials{}, "", errors.New("Failed to get okta credentials from your keyring.  Please make sure you have added okta credentials with `aws-okta add`")
+// GetSAMLAssertion gets a SAML assertion for the AWS app, and returns it with
+// the okta username
+func (p *OktaProvider) GetSAMLAssertion() (SAMLAssertion, string, error) {
+	log.Debugf("Using okta provider (%s)", p.OktaAccountName)
+	oktaCreds, err := p.getOktaCreds()
+	if err != nil {
+		return SAMLAssertion{}, "", err
+	}
+
+	assertionKey := p.OktaAccountName + " " + p.OktaAwsSAMLUrl
+	if p.AssertionCache != nil {
+		if assertion, ok := p.AssertionCache.Get(assertionKey); ok {
+			log.Debugf("Reusing SAML assertion for %s", p.OktaAwsSAMLUrl)
+			return assertion, oktaCreds.Username, nil
+		}
+	}
+
+	// Only one process authenticates with an okta account at a time, so that
+	// the user isn't asked for MFA more than once. The others wait, then
+	// reuse the session cookie it stored.
+	lockTimeout := p.LockTimeout
+	if lockTimeout == 0 {
+		lockTimeout = DefaultLockTimeout
 	}
+	unlock, err := acquireLock("okta "+p.OktaAccountName, lockTimeout)
+	if err != nil {
+		return SAMLAssertion{}, "", err
+	}
+	defer unlock()
 
 	// Check for stored session and device token cookies
 	var cookies OktaCookies
@@ -648,21 +966,31 @@ func (p *OktaProvider) Retrieve() (sts.Credentials, string, error) {
 	if err == nil {
 		cookies.Session = string(cookieItem.Data)
 	}
-	cookieItem2, err := p.Keyring.Get("okta-device-token-cookie")
+	cookieItem2, err := p.Keyring.Get(DeviceTokenKey)
 	if err == nil {
 		cookies.DeviceToken = string(cookieItem2.Data)
 	}
 
-	oktaClient, err := NewOktaClient2(oktaCreds, p.OktaAwsSAMLUrl, cookies, p.MFAConfig)
+	oktaClient, err := NewOktaClient2(oktaCreds, p.OktaAwsSAMLUrl, cookies, p.MFAConfig.WithDefaults(oktaCreds.MFA))
 	if err != nil {
-		return sts.Credentials{}, "", err
+		return SAMLAssertion{}, "", err
+	}
+	oktaClient.NonInteractive = p.NonInteractive
+	oktaClient.PasswordChanged = func(newPassword string) error {
+		oktaCreds.Password = newPassword
+		return p.setOktaCreds(oktaCreds)
 	}
 
-	creds, newCookies, err := oktaClient.AuthenticateProfile3(p.ProfileARN, p.SessionDuration, p.AwsRegion)
+	assertion, err := oktaClient.GetSAMLAssertion()
 	if err != nil {
-		return sts.Credentials{}, "", err
+		return SAMLAssertion{}, "", err
+	}
+	if p.AssertionCache != nil {
+		p.AssertionCache.Put(assertionKey, assertion)
 	}
 
+	newCookies := oktaClient.Cookies()
+
 	log.Debug("pOktaSessionCookieKey: ", p.OktaSessionCookieKey)
 
 	newCookieItem := keyring.Item{
@@ -675,7 +1003,7 @@ func (p *OktaProvider) Retrieve() (sts.Credentials, string, error) {
 	p.Keyring.Set(newCookieItem)
 
 	newCookieItem2 := keyring.Item{
-		Key:                         "okta-device-token-cookie",
+		Key:                         DeviceTokenKey,
 		Data:                        []byte(newCookies.DeviceToken),
 		Label:                       "okta device token",
 		KeychainNotTrustApplication: false,
@@ -683,19 +1011,86 @@ func (p *OktaProvider) Retrieve() (sts.Credentials, string, error) {
 
 	p.Keyring.Set(newCookieItem2)
 
-	return creds, oktaCreds.Username, err
+	return assertion, oktaCreds.Username, nil
 }
 
-func (p *OktaProvider) GetSAMLLoginURL() (*url.URL, error) {
-	item, err := p.Keyring.Get("okta-creds")
+// getOktaCreds returns the okta credentials from the environment if they are
+// set there, and from the keyring otherwise
+func (p *OktaProvider) getOktaCreds() (OktaCreds, error) {
+	if EnvCredentialsSet() {
+		log.Debugf("Using okta credentials from the environment")
+		return CredsFromEnv()
+	}
+
+	item, err := p.Keyring.Get(p.OktaAccountName)
+	if err == keyring.ErrKeyNotFound {
+		return OktaCreds{}, errors.New("Okta credentials are not in your keyring.  Please make sure you have added okta credentials with `aws-okta add`")
+	}
 	if err != nil {
-		log.Debugf("couldnt get okta creds from keyring: %s", err)
-		return &url.URL{}, err
+		log.Debugf("Couldnt get okta creds from keyring: %s", err)
+		return OktaCreds{}, err
 	}
 
 	var oktaCreds OktaCreds
 	if err = json.Unmarshal(item.Data, &oktaCreds); err != nil {
-		return &url.URL{}, errors.New("Failed to get okta credentials from your keyring.  Please make sure you have added okta credentials with `aws-okta add`")
+		return OktaCreds{}, errors.New("Failed to get okta credentials from your keyring.  Please make sure you have added okta credentials with `aws-okta add`")
+	}
+	return oktaCreds, nil
+}
+
+// setOktaCreds replaces the okta credentials in the keyring, eg after the
+// password has been changed
+func (p *OktaProvider) setOktaCreds(oktaCreds OktaCreds) error {
+	if EnvCredentialsSet() {
+		return fmt.Errorf("the okta credentials come from the environment; update %s", EnvOktaPassword)
+	}
+
+	encoded, err := json.Marshal(oktaCreds)
+	if err != nil {
+		return err
+	}
+	return p.Keyring.Set(keyring.Item{
+		Key:                         p.OktaAccountName,
+		Data:                        encoded,
+		Label:                       "okta credentials",
+		KeychainNotTrustApplication: false,
+	})
+}
+
+// assumeRole assumes the role chosen by ChooseRole
+func (p *OktaProvider) assumeRole(assertion SAMLAssertion) (sts.Credentials, error) {
+	principal, role, err := p.ChooseRole(assertion)
+	if err != nil {
+		return sts.Credentials{}, err
+	}
+	return assumeRoleWithSAML(assertion, principal, role, p.SessionDuration, p.AwsRegion)
+}
+
+// ChooseRole returns the principal and role in the assertion matching
+// ProfileARN, or chosen by the user, and remembers which it was
+func (p *OktaProvider) ChooseRole(assertion SAMLAssertion) (string, string, error) {
+	if p.NonInteractive && p.ProfileARN == "" {
+		roles, err := GetAssumableRolesFromSAML(assertion.Resp)
+		if err != nil {
+			return "", "", err
+		}
+		if len(roles) > 1 {
+			return "", "", &InteractionRequiredError{Reason: "a role must be chosen; set role_arn in the profile"}
+		}
+	}
+
+	principal, role, err := GetRoleFromSAML(assertion.Resp, p.ProfileARN)
+	if err != nil {
+		return "", "", err
+	}
+	p.roleARN = role
+	return principal, role, nil
+}
+
+func (p *OktaProvider) GetSAMLLoginURL() (*url.URL, error) {
+	oktaCreds, err := p.getOktaCreds()
+	if err != nil {
+		return &url.URL{}, err
 	}
 
 	var samlURL string
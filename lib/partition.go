@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Partition is a group of AWS regions, such as GovCloud or China, with its own
+// ARNs, STS endpoints and console
+type Partition struct {
+	// ID is the partition as it appears in ARNs, eg aws-us-gov
+	ID string
+	// RegionPrefix is what the partition's region names start with
+	RegionPrefix string
+	// DefaultRegion is used for STS when no region in the partition is
+	// configured
+	DefaultRegion string
+	SigninHost    string
+	ConsoleHost   string
+}
+
+// CommercialPartition is the standard AWS partition
+var CommercialPartition = Partition{
+	ID:            "aws",
+	DefaultRegion: "us-east-1",
+	SigninHost:    "signin.aws.amazon.com",
+	ConsoleHost:   "console.aws.amazon.com",
+}
+
+var partitions = []Partition{
+	CommercialPartition,
+	{
+		ID:            "aws-us-gov",
+		RegionPrefix:  "us-gov-",
+		DefaultRegion: "us-gov-west-1",
+		SigninHost:    "signin.amazonaws-us-gov.com",
+		ConsoleHost:   "console.amazonaws-us-gov.com",
+	},
+	{
+		ID:            "aws-cn",
+		RegionPrefix:  "cn-",
+		DefaultRegion: "cn-north-1",
+		SigninHost:    "signin.amazonaws.cn",
+		ConsoleHost:   "console.amazonaws.cn",
+	},
+}
+
+// PartitionFromARN returns the partition an ARN, such as a role or SAML
+// provider ARN, belongs to
+func PartitionFromARN(arn string) (Partition, error) {
+	parts := strings.SplitN(arn, ":", 3)
+	if len(parts) < 3 || parts[0] != "arn" {
+		return Partition{}, fmt.Errorf("invalid ARN %q", arn)
+	}
+	for _, p := range partitions {
+		if p.ID == parts[1] {
+			return p, nil
+		}
+	}
+	return Partition{}, fmt.Errorf("unsupported AWS partition %q in ARN %q", parts[1], arn)
+}
+
+// PartitionForRegion returns the partition a region belongs to. Regions that
+// aren't in another partition are taken to be commercial.
+func PartitionForRegion(region string) Partition {
+	for _, p := range partitions {
+		if p.RegionPrefix != "" && strings.HasPrefix(region, p.RegionPrefix) {
+			return p
+		}
+	}
+	return CommercialPartition
+}
+
+// STSRegion returns the region to call STS in: region if it is in the
+// partition, otherwise the partition's default. In the commercial partition
+// an empty region is kept, so the SDK's default endpoint is used.
+func (p Partition) STSRegion(region string) string {
+	if region != "" && PartitionForRegion(region).ID == p.ID {
+		return region
+	}
+	if p.ID == CommercialPartition.ID {
+		return region
+	}
+	return p.DefaultRegion
+}
+
+// FederationURL is the endpoint for getting and using console sign-in tokens
+func (p Partition) FederationURL() string {
+	return fmt.Sprintf("https://%s/federation", p.SigninHost)
+}
+
+// SAMLURL is the endpoint SAML assertions are posted to to sign in to the
+// console
+func (p Partition) SAMLURL() string {
+	return fmt.Sprintf("https://%s/saml", p.SigninHost)
+}
+
+// ConsoleURL returns the console's home page, in region if it is set
+func (p Partition) ConsoleURL(region string) string {
+	if region == "" {
+		return fmt.Sprintf("https://%s/", p.ConsoleHost)
+	}
+	if p.ID == CommercialPartition.ID {
+		return fmt.Sprintf("https://%s.%s/console/home?region=%s", region, p.ConsoleHost, region)
+	}
+	return fmt.Sprintf("https://%s/console/home?region=%s", p.ConsoleHost, region)
+}
+
+// ConsoleDestination returns the console URL for dest, which may be a full
+// URL, a path on the console such as /ec2/v2/home, or a service name such as
+// s3. An empty dest is the console's home page.
+func (p Partition) ConsoleDestination(dest, region string) string {
+	switch {
+	case dest == "":
+		return p.ConsoleURL(region)
+	case strings.HasPrefix(dest, "https://"), strings.HasPrefix(dest, "http://"):
+		return dest
+	}
+
+	host := p.ConsoleHost
+	if region != "" && p.ID == CommercialPartition.ID {
+		host = region + "." + host
+	}
+
+	if strings.HasPrefix(dest, "/") {
+		return fmt.Sprintf("https://%s%s", host, dest)
+	}
+
+	if region == "" {
+		return fmt.Sprintf("https://%s/%s/home", host, dest)
+	}
+	return fmt.Sprintf("https://%s/%s/home?region=%s", host, dest, region)
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/segmentio/aws-okta/sessioncache"
+)
+
+func TestPartitionFromARN(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:iam::123456789012:role/admin":                    "aws",
+		"arn:aws-us-gov:iam::123456789012:saml-provider/okta":     "aws-us-gov",
+		"arn:aws-cn:sts::123456789012:assumed-role/admin/someone": "aws-cn",
+	}
+	for arn, id := range cases {
+		p, err := PartitionFromARN(arn)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", arn, err)
+			continue
+		}
+		if p.ID != id {
+			t.Errorf("%s: expected partition %s, got %s", arn, id, p.ID)
+		}
+	}
+
+	for _, arn := range []string{"", "role/admin", "arn:aws-mars:iam::123456789012:role/admin"} {
+		if _, err := PartitionFromARN(arn); err == nil {
+			t.Errorf("%q: expected an error", arn)
+		}
+	}
+}
+
+func TestPartitionSTSRegion(t *testing.T) {
+	gov, _ := PartitionFromARN("arn:aws-us-gov:iam::123456789012:role/admin")
+
+	if r := gov.STSRegion("us-gov-east-1"); r != "us-gov-east-1" {
+		t.Errorf("expected the configured GovCloud region, got %q", r)
+	}
+	if r := gov.STSRegion("us-west-2"); r != "us-gov-west-1" {
+		t.Errorf("expected the GovCloud default region for a commercial region, got %q", r)
+	}
+	if r := gov.STSRegion(""); r != "us-gov-west-1" {
+		t.Errorf("expected the GovCloud default region, got %q", r)
+	}
+	if r := CommercialPartition.STSRegion(""); r != "" {
+		t.Errorf("expected no region for the commercial partition, got %q", r)
+	}
+}
+
+func TestPartitionURLs(t *testing.T) {
+	gov, _ := PartitionFromARN("arn:aws-us-gov:iam::123456789012:role/admin")
+
+	if u := gov.FederationURL(); u != "https://signin.amazonaws-us-gov.com/federation" {
+		t.Errorf("unexpected GovCloud federation URL %s", u)
+	}
+	if u := gov.SAMLURL(); u != "https://signin.amazonaws-us-gov.com/saml" {
+		t.Errorf("unexpected GovCloud SAML URL %s", u)
+	}
+	if u := gov.ConsoleURL("us-gov-west-1"); u != "https://console.amazonaws-us-gov.com/console/home?region=us-gov-west-1" {
+		t.Errorf("unexpected GovCloud console URL %s", u)
+	}
+	if u := CommercialPartition.ConsoleURL("eu-west-1"); u != "https://eu-west-1.console.aws.amazon.com/console/home?region=eu-west-1" {
+		t.Errorf("unexpected console URL %s", u)
+	}
+	if u := CommercialPartition.ConsoleURL(""); u != "https://console.aws.amazon.com/" {
+		t.Errorf("unexpected console URL %s", u)
+	}
+}
+
+func TestPartitionConsoleDestination(t *testing.T) {
+	gov, _ := PartitionFromARN("arn:aws-us-gov:iam::123456789012:role/admin")
+
+	cases := []struct {
+		partition         Partition
+		dest, region, url string
+	}{
+		{CommercialPartition, "", "", "https://console.aws.amazon.com/"},
+		{CommercialPartition, "s3", "", "https://console.aws.amazon.com/s3/home"},
+		{CommercialPartition, "cloudwatch", "eu-west-1", "https://eu-west-1.console.aws.amazon.com/cloudwatch/home?region=eu-west-1"},
+		{CommercialPartition, "/ec2/v2/home?region=eu-west-1#Instances:", "eu-west-1", "https://eu-west-1.console.aws.amazon.com/ec2/v2/home?region=eu-west-1#Instances:"},
+		{CommercialPartition, "https://example.com/x", "eu-west-1", "https://example.com/x"},
+		{gov, "s3", "us-gov-west-1", "https://console.amazonaws-us-gov.com/s3/home?region=us-gov-west-1"},
+	}
+	for _, c := range cases {
+		if u := c.partition.ConsoleDestination(c.dest, c.region); u != c.url {
+			t.Errorf("%s %q in %q: expected %s, got %s", c.partition.ID, c.dest, c.region, c.url, u)
+		}
+	}
+}
+
+// cachedSessions returns session for every key
+type cachedSessions struct {
+	session *sessioncache.Session
+}
+
+func (c *cachedSessions) Get(sessioncache.Key) (*sessioncache.Session, error) {
+	return c.session, nil
+}
+
+func (c *cachedSessions) Put(_ sessioncache.Key, session *sessioncache.Session) error {
+	c.session = session
+	return nil
+}
+
+func TestProviderPartitionFromCachedSession(t *testing.T) {
+	profiles := Profiles{"gov": {"aws_saml_url": "home/amazon_aws/xyz/123"}}
+	p, err := NewProvider(keyring.NewArrayKeyring(nil), "gov", ProviderOptions{Profiles: profiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.sessions = &cachedSessions{&sessioncache.Session{
+		Name:    "someone",
+		RoleARN: "arn:aws-us-gov:iam::123456789012:role/admin",
+		Credentials: sts.Credentials{
+			AccessKeyId:     aws.String("AKIDGOV1"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}}
+
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+	if id := p.Partition().ID; id != "aws-us-gov" {
+		t.Errorf("expected the partition of the cached session's role, got %s", id)
+	}
+}
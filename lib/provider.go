@@ -28,6 +28,8 @@ const (
 
 	DefaultSessionDuration    = time.Hour * 4
 	DefaultAssumeRoleDuration = time.Minute * 15
+	DefaultExpiryWindow       = time.Minute * 5
+	DefaultLockTimeout        = time.Minute * 5
 )
 
 type ProviderOptions struct {
@@ -40,6 +42,14 @@ type ProviderOptions struct {
 	// if true, use store_singlekritem SessionCache (new)
 	// if false, use store_kritempersession SessionCache (old)
 	SessionCacheSingleItem bool
+	// if set, SAML assertions are shared with other providers using the same
+	// cache, so that several profiles can be retrieved with one okta login
+	SAMLAssertionCache *SAMLAssertionCache
+	// if true, fail with InteractionRequiredError instead of prompting
+	NonInteractive bool
+	// how long to wait for another process authenticating the same profile
+	// or okta account
+	LockTimeout time.Duration
 }
 
 func (o ProviderOptions) Validate() error {
@@ -65,6 +75,12 @@ func (o ProviderOptions) ApplyDefaults() ProviderOptions {
 	if o.SessionDuration == 0 {
 		o.SessionDuration = DefaultSessionDuration
 	}
+	if o.ExpiryWindow == 0 {
+		o.ExpiryWindow = DefaultExpiryWindow
+	}
+	if o.LockTimeout == 0 {
+		o.LockTimeout = DefaultLockTimeout
+	}
 	return o
 }
 
@@ -82,6 +98,8 @@ type Provider struct {
 	sessions               SessionCacheInterface
 	profiles               Profiles
 	defaultRoleSessionName string
+	// the role assumed with SAML, when it wasn't configured
+	samlRoleARN string
 }
 
 func NewProvider(k keyring.Keyring, profile string, opts ProviderOptions) (*Provider, error) {
@@ -111,9 +129,6 @@ func NewProvider(k keyring.Keyring, profile string, opts ProviderOptions) (*Prov
 func (p *Provider) Retrieve() (credentials.Value, error) {
 
 	window := p.ExpiryWindow
-	if window == 0 {
-		window = time.Minute * 5
-	}
 
 	// TODO(nick): why are we using the source profile name and not the actual profile's name?
 	source := sourceProfile(p.profile, p.profiles)
@@ -129,24 +144,43 @@ func (p *Provider) Retrieve() (credentials.Value, error) {
 	}
 
 	var creds sts.Credentials
-	if cachedSession, err := p.sessions.Get(key); err != nil {
-		creds, err = p.getSamlSessionCreds()
-		if err != nil {
-			return credentials.Value{}, xerrors.Errorf("getting creds via SAML: %w", err)
-		}
-		newSession := sessioncache.Session{
-			Name:        p.roleSessionName(),
-			Credentials: creds,
-		}
-		if err = p.sessions.Put(key, &newSession); err != nil {
-			return credentials.Value{}, xerrors.Errorf("putting to sessioncache", err)
+	cachedSession, err := p.getCachedSession(key)
+	if err != nil {
+		// Only one process authenticates for a profile at a time. The others
+		// wait for it, then use the session it cached.
+		unlock, lockErr := acquireLock("profile "+source, p.LockTimeout)
+		if lockErr != nil {
+			return credentials.Value{}, lockErr
 		}
+		cachedSession, err = p.getCachedSession(key)
+		if err != nil {
+			creds, err = p.getSamlSessionCreds()
+			if err != nil {
+				unlock()
+				return credentials.Value{}, xerrors.Errorf("getting creds via SAML: %w", err)
+			}
+			newSession := sessioncache.Session{
+				Name:        p.roleSessionName(),
+				RoleARN:     p.samlRoleARN,
+				Credentials: creds,
+			}
+			if err = p.sessions.Put(key, &newSession); err != nil {
+				unlock()
+				return credentials.Value{}, xerrors.Errorf("putting to sessioncache", err)
+			}
 
-		// TODO(nick): not really clear why this is done
-		p.defaultRoleSessionName = newSession.Name
-	} else {
+			// TODO(nick): not really clear why this is done
+			p.defaultRoleSessionName = newSession.Name
+		}
+		unlock()
+	}
+	if cachedSession != nil {
 		creds = cachedSession.Credentials
 		p.defaultRoleSessionName = cachedSession.Name
+		// keep the session's partition when it's taken from the SAML role
+		if cachedSession.RoleARN != "" {
+			p.samlRoleARN = cachedSession.RoleARN
+		}
 	}
 
 	log.Debugf("Using session %s, expires in %s",
@@ -183,6 +217,22 @@ func (p *Provider) Retrieve() (credentials.Value, error) {
 	return value, nil
 }
 
+// getCachedSession returns the cached session for key, unless it expires
+// within the expiry window
+func (p *Provider) getCachedSession(key sessioncache.Key) (*sessioncache.Session, error) {
+	cachedSession, err := p.sessions.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if cachedSession.Expiration.Before(time.Now().Add(p.ExpiryWindow)) {
+		// a session this close to expiry is of no use to long running callers
+		// that refresh ahead of time, so treat it as a miss
+		log.Debugf("cached session expires within %s, refreshing", p.ExpiryWindow)
+		return nil, sessioncache.ErrSessionExpired
+	}
+	return cachedSession, nil
+}
+
 func (p *Provider) GetExpiration() time.Time {
 	return p.expires
 }
@@ -197,30 +247,41 @@ func (p *Provider) getSamlURL() (string, error) {
 }
 
 func (p *Provider) getOktaSessionCookieKey() string {
-	oktaSessionCookieKey, profile, err := p.profiles.GetValue(p.profile, "okta_session_cookie_key")
-	if err != nil {
-		return "okta-session-cookie"
-	}
-	log.Debugf("Using okta_session_cookie_key from profile: %s", profile)
+	oktaSessionCookieKey := p.profiles.SessionCookieKey(p.profile)
+	log.Debugf("Using okta session cookie key: %s", oktaSessionCookieKey)
 	return oktaSessionCookieKey
 }
 
 func (p *Provider) getOktaAccountName() string {
-	oktaAccountName, profile, err := p.profiles.GetValue(p.profile, "okta_account_name")
+	oktaAccountName := ResolveAccount(p.keyring, p.profiles.OktaAccount(p.profile))
+	log.Debugf("Using okta account: %s", oktaAccountName)
+	return oktaAccountName
+}
+
+func (p *Provider) getSamlSessionCreds() (sts.Credentials, error) {
+	provider, err := p.oktaProvider()
 	if err != nil {
-		return "okta-creds"
+		return sts.Credentials{}, err
+	}
+
+	creds, oktaUsername, err := provider.Retrieve()
+	if err != nil {
+		return sts.Credentials{}, err
 	}
-	log.Debugf("Using okta_account_name: %s from profile: %s", oktaAccountName, profile)
-	return "okta-creds-" + oktaAccountName
+	p.defaultRoleSessionName = oktaUsername
+	p.samlRoleARN = provider.roleARN
+
+	return creds, nil
 }
 
-func (p *Provider) getSamlSessionCreds() (sts.Credentials, error) {
+// oktaProvider returns the OktaProvider for the profile's okta app and role
+func (p *Provider) oktaProvider() (*OktaProvider, error) {
 	var profileARN string
 	var ok bool
 	source := sourceProfile(p.profile, p.profiles)
 	oktaAwsSAMLUrl, err := p.getSamlURL()
 	if err != nil {
-		return sts.Credentials{}, err
+		return nil, err
 	}
 	oktaSessionCookieKey := p.getOktaSessionCookieKey()
 	oktaAccountName := p.getOktaAccountName()
@@ -244,7 +305,7 @@ func (p *Provider) getSamlSessionCreds() (sts.Credentials, error) {
 		}
 	}
 
-	provider := OktaProvider{
+	provider := &OktaProvider{
 		MFAConfig:            p.ProviderOptions.MFAConfig,
 		Keyring:              p.keyring,
 		ProfileARN:           profileARN,
@@ -252,44 +313,53 @@ func (p *Provider) getSamlSessionCreds() (sts.Credentials, error) {
 		OktaAwsSAMLUrl:       oktaAwsSAMLUrl,
 		OktaSessionCookieKey: oktaSessionCookieKey,
 		OktaAccountName:      oktaAccountName,
+		AssertionCache:       p.SAMLAssertionCache,
+		NonInteractive:       p.NonInteractive,
+		LockTimeout:          p.LockTimeout,
 	}
 
 	if region := p.profiles[source]["region"]; region != "" {
 		provider.AwsRegion = region
 	}
 
-	creds, oktaUsername, err := provider.Retrieve()
-	if err != nil {
-		return sts.Credentials{}, err
-	}
-	p.defaultRoleSessionName = oktaUsername
+	return provider, nil
+}
 
-	return creds, nil
+// SAMLSignin is what signing in to the AWS console with SAML takes
+type SAMLSignin struct {
+	Assertion SAMLAssertion
+	// Principal and Role are the SAML provider and role to sign in with
+	Principal string
+	Role      string
 }
 
-func (p *Provider) GetSAMLLoginURL() (*url.URL, error) {
-	source := sourceProfile(p.profile, p.profiles)
-	oktaAwsSAMLUrl, err := p.getSamlURL()
+// GetSAMLSignin authenticates with okta like Retrieve, but returns the SAML
+// assertion and the role to use with it instead of assuming the role. The
+// role is the one the profile's source profile would assume.
+func (p *Provider) GetSAMLSignin() (SAMLSignin, error) {
+	provider, err := p.oktaProvider()
 	if err != nil {
-		return &url.URL{}, err
+		return SAMLSignin{}, err
 	}
-	oktaSessionCookieKey := p.getOktaSessionCookieKey()
-	oktaAccountName := p.getOktaAccountName()
 
-	profileARN := p.profiles[source]["role_arn"]
+	assertion, _, err := provider.GetSAMLAssertion()
+	if err != nil {
+		return SAMLSignin{}, err
+	}
 
-	provider := OktaProvider{
-		MFAConfig:            p.ProviderOptions.MFAConfig,
-		Keyring:              p.keyring,
-		ProfileARN:           profileARN,
-		SessionDuration:      p.SessionDuration,
-		OktaAwsSAMLUrl:       oktaAwsSAMLUrl,
-		OktaSessionCookieKey: oktaSessionCookieKey,
-		OktaAccountName:      oktaAccountName,
+	principal, role, err := provider.ChooseRole(assertion)
+	if err != nil {
+		return SAMLSignin{}, err
 	}
+	p.samlRoleARN = role
 
-	if region := p.profiles[source]["region"]; region != "" {
-		provider.AwsRegion = region
+	return SAMLSignin{Assertion: assertion, Principal: principal, Role: role}, nil
+}
+
+func (p *Provider) GetSAMLLoginURL() (*url.URL, error) {
+	provider, err := p.oktaProvider()
+	if err != nil {
+		return &url.URL{}, err
 	}
 
 	loginURL, err := provider.GetSAMLLoginURL()
@@ -299,13 +369,53 @@ func (p *Provider) GetSAMLLoginURL() (*url.URL, error) {
 	return loginURL, nil
 }
 
+// Partition returns the AWS partition of the profile's credentials. It is
+// taken from the profile's role ARNs, the role assumed with SAML or the
+// profile's region, in that order.
+func (p *Provider) Partition() Partition {
+	source := sourceProfile(p.profile, p.profiles)
+	arns := []string{
+		p.AssumeRoleArn,
+		p.profiles[p.profile]["role_arn"],
+		p.profiles[source]["role_arn"],
+		p.samlRoleARN,
+	}
+	for _, arn := range arns {
+		if arn == "" {
+			continue
+		}
+		partition, err := PartitionFromARN(arn)
+		if err != nil {
+			log.Debugf("Ignoring partition of %s: %s", arn, err)
+			continue
+		}
+		return partition
+	}
+	return PartitionForRegion(p.profiles[source]["region"])
+}
+
+// stsConfig returns the config for calling STS with creds, in the profile's
+// partition
+func (p *Provider) stsConfig(accessKeyID, secretAccessKey, sessionToken string) *aws.Config {
+	config := aws.Config{Credentials: credentials.NewStaticCredentials(
+		accessKeyID,
+		secretAccessKey,
+		sessionToken,
+	)}
+	region := p.profiles[sourceProfile(p.profile, p.profiles)]["region"]
+	if region := p.Partition().STSRegion(region); region != "" {
+		config.WithRegion(region)
+	}
+	return &config
+}
+
 // assumeRoleFromSession takes a session created with an okta SAML login and uses that to assume a role
 func (p *Provider) assumeRoleFromSession(creds sts.Credentials, roleArn string) (sts.Credentials, error) {
-	client := sts.New(aws_session.New(&aws.Config{Credentials: credentials.NewStaticCredentials(
+	client := sts.New(aws_session.New(p.stsConfig(
 		*creds.AccessKeyId,
 		*creds.SecretAccessKey,
 		*creds.SessionToken,
-	)}))
+	)))
 
 	input := &sts.AssumeRoleInput{
 		RoleArn:         aws.String(roleArn),
@@ -341,15 +451,11 @@ func (p *Provider) roleSessionName() string {
 // GetRoleARN uses temporary credentials to call AWS's get-caller-identity and
 // returns the assumed role's ARN
 func (p *Provider) GetRoleARNWithRegion(creds credentials.Value) (string, error) {
-	config := aws.Config{Credentials: credentials.NewStaticCredentials(
+	client := sts.New(aws_session.New(p.stsConfig(
 		creds.AccessKeyID,
 		creds.SecretAccessKey,
 		creds.SessionToken,
-	)}
-	if region := p.profiles[sourceProfile(p.profile, p.profiles)]["region"]; region != "" {
-		config.WithRegion(region)
-	}
-	client := sts.New(aws_session.New(&config))
+	)))
 
 	indentity, err := client.GetCallerIdentity(&sts.GetCallerIdentityInput{})
 	if err != nil {
@@ -368,11 +474,11 @@ func (p *Provider) GetRoleARN() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	client := sts.New(aws_session.New(&aws.Config{Credentials: credentials.NewStaticCredentials(
+	client := sts.New(aws_session.New(p.stsConfig(
 		*creds.AccessKeyId,
 		*creds.SecretAccessKey,
 		*creds.SessionToken,
-	)}))
+	)))
 	indentity, err := client.GetCallerIdentity(&sts.GetCallerIdentityInput{})
 	if err != nil {
 		log.Errorf("Error getting caller identity: %s", err.Error())
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how many times RSA SecurID may ask for a next tokencode or new PIN before
+// giving up
+const maxRSAChallenges = 3
+
+// challengeRSA answers RSA SecurID's follow-up challenges to a passcode.
+// They come back as MFA_CHALLENGE with factorResult CHALLENGE: when the
+// token is out of sync, the next tokencode is needed; in new PIN mode, a new
+// PIN is set, after which a passcode made with it is needed.
+func (o *OktaClient) challengeRSA(payload []byte, oktaFactorId string) error {
+	var code OktaStateToken
+	if err := json.Unmarshal(payload, &code); err != nil {
+		return err
+	}
+
+	for attempt := 0; o.UserAuth.Status == AuthnStatusMFAChallenge && o.UserAuth.FactorResult == FactorResultChallenge; attempt++ {
+		if attempt == maxRSAChallenges {
+			return errors.New("RSA SecurID kept asking for more; check your token with your okta admin")
+		}
+		message := o.UserAuth.FactorResultMessage
+		log.Debugf("RSA SecurID challenge: %s", message)
+		if o.NonInteractive {
+			if message == "" {
+				message = "the next tokencode"
+			}
+			return &InteractionRequiredError{Reason: "RSA SecurID asked for more: " + message}
+		}
+
+		code.StateToken = o.UserAuth.StateToken
+		if isRSANewPIN(message) {
+			pin, err := promptRSAPIN(message)
+			if err != nil {
+				return err
+			}
+			code.PassCode = pin
+			code.NextPassCode = ""
+		} else if isRSANewPassCode(message) {
+			passCode, err := prompt("Enter a passcode made with your new PIN", true)
+			if err != nil {
+				return err
+			}
+			code.PassCode = passCode
+			code.NextPassCode = ""
+		} else {
+			if message == "" {
+				message = "RSA SecurID needs the next tokencode"
+			}
+			fmt.Fprintf(os.Stderr, "%s\n", message)
+			next, err := prompt("Wait for the tokencode to change, then enter it", false)
+			if err != nil {
+				return err
+			}
+			code.NextPassCode = next
+		}
+
+		payload, err := json.Marshal(code)
+		if err != nil {
+			return err
+		}
+		if err := o.Get("POST", "api/v1/authn/factors/"+oktaFactorId+"/verify", payload, &o.UserAuth, "json"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promptRSAPIN asks the user for a new PIN twice, up to maxRSAChallenges
+// times until they match
+func promptRSAPIN(message string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s\n", message)
+	for attempt := 1; ; attempt++ {
+		pin, err := prompt("New RSA SecurID PIN", true)
+		if err != nil {
+			return "", err
+		}
+		confirm, err := prompt("Confirm new RSA SecurID PIN", true)
+		if err != nil {
+			return "", err
+		}
+		if pin != "" && pin == confirm {
+			return pin, nil
+		}
+		if attempt == maxRSAChallenges {
+			return "", errors.New("the new RSA SecurID PINs didn't match")
+		}
+		fmt.Fprintln(os.Stderr, "The PINs don't match; try again")
+	}
+}
+
+func isRSANewPIN(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "new pin") && !strings.Contains(message, "passcode")
+}
+
+func isRSANewPassCode(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "new pin") && strings.Contains(message, "passcode")
+}
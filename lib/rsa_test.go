@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func rsaChallenge(message string) OktaUserAuthn {
+	auth := OktaUserAuthn{
+		Status:              AuthnStatusMFAChallenge,
+		StateToken:          "state",
+		FactorResult:        FactorResultChallenge,
+		FactorResultMessage: message,
+	}
+	auth.Embedded.Factor = OktaUserAuthnFactor{Id: "rsa1", Provider: "RSA", FactorType: "token"}
+	return auth
+}
+
+func TestAuthenticateRSANextTokencode(t *testing.T) {
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "rsa1", Provider: "RSA", FactorType: "token"}))
+	stub.on("/api/v1/authn/factors/rsa1/verify",
+		rsaChallenge("Wait for token to change, then enter the new tokencode"),
+		OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"},
+	)
+	_, restore := stubPrompt(t, "1234123456", "654321")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	verifies := stub.requests["/api/v1/authn/factors/rsa1/verify"]
+	if len(verifies) != 2 {
+		t.Fatalf("expected the passcode and next tokencode to be verified, got %v", verifies)
+	}
+	if verifies[0]["passCode"] != "1234123456" || verifies[0]["nextPassCode"] != nil {
+		t.Errorf("unexpected first verification %v", verifies[0])
+	}
+	if verifies[1]["passCode"] != "1234123456" || verifies[1]["nextPassCode"] != "654321" {
+		t.Errorf("expected the next tokencode with the passcode, got %v", verifies[1])
+	}
+}
+
+func TestAuthenticateRSANewPIN(t *testing.T) {
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "rsa1", Provider: "RSA", FactorType: "token"}))
+	stub.on("/api/v1/authn/factors/rsa1/verify",
+		rsaChallenge("New PIN required"),
+		rsaChallenge("Enter a passcode made with your new PIN"),
+		OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"},
+	)
+	prompts, restore := stubPrompt(t, "123456", "4321", "9999", "4321", "4321", "4321654321")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*prompts) != 6 {
+		t.Errorf("expected a mismatched PIN to be asked for again, got prompts %q", *prompts)
+	}
+	verifies := stub.requests["/api/v1/authn/factors/rsa1/verify"]
+	if len(verifies) != 3 || verifies[1]["passCode"] != "4321" || verifies[2]["passCode"] != "4321654321" {
+		t.Errorf("expected the new PIN, then a passcode made with it; got %v", verifies)
+	}
+}
+
+func TestAuthenticateRSAGivesUp(t *testing.T) {
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "rsa1", Provider: "RSA", FactorType: "token"}))
+	stub.on("/api/v1/authn/factors/rsa1/verify", rsaChallenge("Enter the next tokencode"))
+	_, restore := stubPrompt(t, "1234123456", "1", "2", "3")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+
+	if err := o.AuthenticateUser(); err == nil {
+		t.Error("expected endless challenges to fail")
+	}
+}
+
+func TestAuthenticateRSAPINMismatch(t *testing.T) {
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "rsa1", Provider: "RSA", FactorType: "token"}))
+	stub.on("/api/v1/authn/factors/rsa1/verify", rsaChallenge("New PIN required"))
+	_, restore := stubPrompt(t, "123456", "1", "2", "3", "4", "5", "6")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+
+	if err := o.AuthenticateUser(); err == nil || !strings.Contains(err.Error(), "didn't match") {
+		t.Errorf("expected PINs that never match to fail, got %v", err)
+	}
+	if verifies := stub.requests["/api/v1/authn/factors/rsa1/verify"]; len(verifies) != 1 {
+		t.Errorf("expected no PIN to be sent, got %v", verifies)
+	}
+}
+
+func TestAuthenticateRSANonInteractive(t *testing.T) {
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "rsa1", Provider: "RSA", FactorType: "token"}))
+	stub.on("/api/v1/authn/factors/rsa1/verify", rsaChallenge("New PIN required"))
+	o, done := stub.client()
+	defer done()
+	o.NonInteractive = true
+	o.MFAConfig.TokenCommand = "echo 1234123456"
+
+	var interactionErr *InteractionRequiredError
+	if err := o.AuthenticateUser(); !xerrors.As(err, &interactionErr) || !strings.Contains(interactionErr.Reason, "RSA SecurID") {
+		t.Errorf("expected an InteractionRequiredError for the new PIN, got %v", err)
+	}
+	if verifies := stub.requests["/api/v1/authn/factors/rsa1/verify"]; len(verifies) != 1 || verifies[0]["passCode"] != "1234123456" {
+		t.Errorf("expected only the passcode from mfa_token_command to be sent, got %v", verifies)
+	}
+}
+
+func TestAuthenticateSymantecVIP(t *testing.T) {
+	defer func(interval time.Duration) { mfaPollInterval = interval }(mfaPollInterval)
+	mfaPollInterval = time.Millisecond
+
+	waiting := OktaUserAuthn{Status: AuthnStatusMFAChallenge, StateToken: "state", FactorResult: FactorResultWaiting}
+	waiting.Embedded.Factor = OktaUserAuthnFactor{Id: "vip1", Provider: "SYMANTEC", FactorType: "push"}
+
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "vip1", Provider: "SYMANTEC", FactorType: "push"}))
+	stub.on("/api/v1/authn/factors/vip1/verify",
+		waiting,
+		waiting,
+		OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"},
+	)
+	o, done := stub.client()
+	if err := o.AuthenticateUser(); err != nil {
+		t.Errorf("push: %v", err)
+	}
+	done()
+	if polls := len(stub.requests["/api/v1/authn/factors/vip1/verify"]); polls != 3 {
+		t.Errorf("push: expected okta to be polled until the push was approved, got %d requests", polls)
+	}
+
+	stub = newOktaStub(t)
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "vip2", Provider: "SYMANTEC", FactorType: "token"}))
+	stub.on("/api/v1/authn/factors/vip2/verify", OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"})
+	_, restore := stubPrompt(t, "875498")
+	defer restore()
+	o, done = stub.client()
+	defer done()
+	if err := o.AuthenticateUser(); err != nil {
+		t.Errorf("token: %v", err)
+	}
+	if verifies := stub.requests["/api/v1/authn/factors/vip2/verify"]; len(verifies) != 1 || verifies[0]["passCode"] != "875498" {
+		t.Errorf("token: expected the code to be verified, got %v", verifies)
+	}
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// assertions are short lived; this is how long one is reused when it doesn't
+// say when it expires, and how long before its stated expiry it stops being used
+const samlAssertionLifetime = 5 * time.Minute
+const samlAssertionMargin = 30 * time.Second
+
+type cachedSAMLAssertion struct {
+	assertion SAMLAssertion
+	expires   time.Time
+}
+
+// SAMLAssertionCache keeps SAML assertions in memory so that one okta
+// authentication can be used to get credentials for several profiles in the
+// same process. It is safe for concurrent use.
+type SAMLAssertionCache struct {
+	mu         sync.Mutex
+	assertions map[string]cachedSAMLAssertion
+}
+
+func NewSAMLAssertionCache() *SAMLAssertionCache {
+	return &SAMLAssertionCache{assertions: map[string]cachedSAMLAssertion{}}
+}
+
+// Get returns the assertion stored under key, if it hasn't expired
+func (c *SAMLAssertionCache) Get(key string) (SAMLAssertion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.assertions[key]
+	if !ok {
+		return SAMLAssertion{}, false
+	}
+	if !time.Now().Before(cached.expires) {
+		log.Debugf("cached SAML assertion for %s expired", key)
+		delete(c.assertions, key)
+		return SAMLAssertion{}, false
+	}
+	return cached.assertion, true
+}
+
+func (c *SAMLAssertionCache) Put(key string, assertion SAMLAssertion) {
+	expires := time.Now().Add(samlAssertionLifetime)
+	if assertion.Resp != nil {
+		notOnOrAfter, err := time.Parse(time.RFC3339, assertion.Resp.Assertion.Conditions.NotOnOrAfter)
+		if err == nil {
+			expires = notOnOrAfter.Add(-samlAssertionMargin)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.assertions[key] = cachedSAMLAssertion{assertion: assertion, expires: expires}
+}
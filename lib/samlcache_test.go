@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/aws-okta/lib/saml"
+)
+
+func TestSAMLAssertionCache(t *testing.T) {
+	cache := NewSAMLAssertionCache()
+
+	t.Run("missing key", func(t *testing.T) {
+		if _, ok := cache.Get("account url"); ok {
+			t.Error("an empty cache should not return an assertion")
+		}
+	})
+
+	t.Run("assertion without expiry", func(t *testing.T) {
+		cache.Put("account url", SAMLAssertion{RawData: []byte("a")})
+		assertion, ok := cache.Get("account url")
+		if !ok {
+			t.Fatal("expected a cached assertion")
+		}
+		if string(assertion.RawData) != "a" {
+			t.Errorf("expected cached assertion 'a', got %q", assertion.RawData)
+		}
+	})
+
+	t.Run("expired assertion", func(t *testing.T) {
+		resp := &saml.Response{}
+		resp.Assertion.Conditions.NotOnOrAfter = time.Now().Add(10 * time.Second).UTC().Format(time.RFC3339)
+		cache.Put("account other-url", SAMLAssertion{Resp: resp, RawData: []byte("b")})
+		if _, ok := cache.Get("account other-url"); ok {
+			t.Error("an assertion about to expire should not be reused")
+		}
+	})
+
+	t.Run("assertion with expiry", func(t *testing.T) {
+		resp := &saml.Response{}
+		resp.Assertion.Conditions.NotOnOrAfter = time.Now().Add(5 * time.Minute).UTC().Format(time.RFC3339)
+		cache.Put("account other-url", SAMLAssertion{Resp: resp, RawData: []byte("c")})
+		if _, ok := cache.Get("account other-url"); !ok {
+			t.Error("expected a cached assertion")
+		}
+	})
+}
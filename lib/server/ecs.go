@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	log "github.com/sirupsen/logrus"
+)
+
+const ecsCredentialsPath = "/creds"
+
+// ecsCredentials is the payload of the container credentials protocol, as read
+// by the SDKs when AWS_CONTAINER_CREDENTIALS_FULL_URI is set
+type ecsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+type ecsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ECSServer serves credentials over the ECS container credentials protocol on
+// a loopback address. Requests must carry the server's authorization token.
+type ECSServer struct {
+	listener net.Listener
+	server   *http.Server
+	token    string
+	creds    *refresher
+}
+
+// NewECSServer listens on a random loopback port; call Serve to start
+// answering requests
+func NewECSServer(p Provider) (*ECSServer, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ECSServer{
+		listener: listener,
+		token:    token,
+		creds:    &refresher{provider: p},
+	}
+	s.server = &http.Server{Handler: s}
+
+	return s, nil
+}
+
+// URL is the value for AWS_CONTAINER_CREDENTIALS_FULL_URI
+func (s *ECSServer) URL() string {
+	return fmt.Sprintf("http://%s%s", s.listener.Addr().String(), ecsCredentialsPath)
+}
+
+// AuthToken is the value for AWS_CONTAINER_AUTHORIZATION_TOKEN
+func (s *ECSServer) AuthToken() string {
+	return s.token
+}
+
+// Seed serves creds, which the provider has already retrieved, until they
+// expire, so that the first request doesn't retrieve them again
+func (s *ECSServer) Seed(creds credentials.Value) {
+	s.creds.seed(creds)
+}
+
+// Serve blocks answering requests until Close is called
+func (s *ECSServer) Serve() error {
+	log.Debugf("Serving container credentials on %s", s.URL())
+	if err := s.server.Serve(s.listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *ECSServer) Close() error {
+	return s.server.Close()
+}
+
+func (s *ECSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || r.URL.Path != ecsCredentialsPath {
+		writeJSON(w, http.StatusNotFound, ecsError{"NotFound", "not found"})
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte(s.token)) != 1 {
+		log.Debugf("rejected credentials request from %s: bad authorization token", r.RemoteAddr)
+		writeJSON(w, http.StatusForbidden, ecsError{"AccessDenied", "invalid authorization token"})
+		return
+	}
+
+	creds, expiration, err := s.creds.Get()
+	if err != nil {
+		log.Errorf("Failed to retrieve credentials: %s", err)
+		writeJSON(w, http.StatusInternalServerError, ecsError{"CredentialsError", err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ecsCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      expiration.UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvider hands out numbered credentials, one per Retrieve call
+type fakeProvider struct {
+	retrieved int
+	expires   time.Time
+	expired   bool
+}
+
+func (p *fakeProvider) Retrieve() (credentials.Value, error) {
+	p.retrieved++
+	p.expired = false
+	return credentials.Value{
+		AccessKeyID:     fmt.Sprintf("AKID%d", p.retrieved),
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+	}, nil
+}
+
+func (p *fakeProvider) IsExpired() bool {
+	return p.expired
+}
+
+func (p *fakeProvider) GetExpiration() time.Time {
+	return p.expires
+}
+
+func TestECSServer(t *testing.T) {
+	expires := time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &fakeProvider{expires: expires}
+	s, err := NewECSServer(p)
+	if err != nil {
+		t.Fatalf("error creating server: %s", err)
+	}
+	defer s.Close()
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", s.URL(), nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("missing token is rejected", func(t *testing.T) {
+		rec := get("")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, 0, p.retrieved)
+	})
+
+	t.Run("wrong token is rejected", func(t *testing.T) {
+		rec := get("not-the-token")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("valid token returns credentials", func(t *testing.T) {
+		rec := get(s.AuthToken())
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var got ecsCredentials
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("error decoding response: %s", err)
+		}
+		assert.Equal(t, "AKID1", got.AccessKeyID)
+		assert.Equal(t, "token", got.Token)
+		assert.Equal(t, "3000-01-01T00:00:00Z", got.Expiration)
+	})
+
+	t.Run("credentials are reused until expired", func(t *testing.T) {
+		get(s.AuthToken())
+		assert.Equal(t, 1, p.retrieved)
+
+		p.expired = true
+		rec := get(s.AuthToken())
+
+		var got ecsCredentials
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, 2, p.retrieved)
+		assert.Equal(t, "AKID2", got.AccessKeyID)
+	})
+}
+
+func TestECSServerSeed(t *testing.T) {
+	p := &fakeProvider{expires: time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s, err := NewECSServer(p)
+	if err != nil {
+		t.Fatalf("error creating server: %s", err)
+	}
+	defer s.Close()
+	s.Seed(credentials.Value{AccessKeyID: "AKID0", SecretAccessKey: "secret", SessionToken: "token"})
+
+	req := httptest.NewRequest("GET", s.URL(), nil)
+	req.Header.Set("Authorization", s.AuthToken())
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	var got ecsCredentials
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("error decoding response: %s", err)
+	}
+	assert.Equal(t, "AKID0", got.AccessKeyID)
+	assert.Equal(t, 0, p.retrieved)
+}
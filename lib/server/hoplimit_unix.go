@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import "syscall"
+
+func setHopLimit(fd uintptr, ipv6 bool, hops int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, hops)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, hops)
+}
@@ -0,0 +1,10 @@
+package server
+
+import "syscall"
+
+func setHopLimit(fd uintptr, ipv6 bool, hops int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, hops)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, hops)
+}
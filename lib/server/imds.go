@@ -0,0 +1,352 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	imdsTokenHeader    = "X-aws-ec2-metadata-token"
+	imdsTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	imdsMaxTokenTTL    = 6 * time.Hour
+
+	imdsCredentialsPath = "/latest/meta-data/iam/security-credentials/"
+
+	// DefaultIMDSHopLimit matches the EC2 default for token responses
+	DefaultIMDSHopLimit = 1
+)
+
+// IMDSConfig describes the instance the IMDS server pretends to be
+type IMDSConfig struct {
+	// Addr is the address to listen on, eg 127.0.0.1:9911
+	Addr string
+	// RoleName is served as the instance profile's role
+	RoleName  string
+	AccountID string
+	Region    string
+	// Partition is used in the instance profile ARN; aws if empty
+	Partition string
+	// HopLimit is the IP TTL set on token responses, so that tokens can't be
+	// obtained from further away than on EC2; 0 leaves the system default
+	HopLimit int
+	// RequireToken rejects IMDSv1 (tokenless) requests, like an instance
+	// with http-tokens=required
+	RequireToken bool
+}
+
+type imdsCredentials struct {
+	Code            string `json:"Code"`
+	LastUpdated     string `json:"LastUpdated"`
+	Type            string `json:"Type"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+type imdsInfo struct {
+	Code               string `json:"Code"`
+	LastUpdated        string `json:"LastUpdated"`
+	InstanceProfileArn string `json:"InstanceProfileArn"`
+	InstanceProfileId  string `json:"InstanceProfileId"`
+}
+
+type imdsIdentityDocument struct {
+	AccountID        string `json:"accountId"`
+	Architecture     string `json:"architecture"`
+	AvailabilityZone string `json:"availabilityZone"`
+	ImageID          string `json:"imageId"`
+	InstanceID       string `json:"instanceId"`
+	InstanceType     string `json:"instanceType"`
+	PendingTime      string `json:"pendingTime"`
+	PrivateIP        string `json:"privateIp"`
+	Region           string `json:"region"`
+	Version          string `json:"version"`
+}
+
+// IMDSServer emulates the parts of the EC2 instance metadata service that the
+// SDKs use to find credentials and region, in both IMDSv1 and IMDSv2 flavors
+type IMDSServer struct {
+	IMDSConfig
+	listener net.Listener
+	server   *http.Server
+	creds    *refresher
+	started  time.Time
+
+	tokensMu sync.Mutex
+	tokens   map[string]time.Time
+
+	// open connections by remote address, so handlers can set the hop limit
+	conns sync.Map
+}
+
+// NewIMDSServer listens on conf.Addr; call Serve to start answering requests
+func NewIMDSServer(p Provider, conf IMDSConfig) (*IMDSServer, error) {
+	if conf.RoleName == "" {
+		return nil, fmt.Errorf("a role name is required")
+	}
+
+	listener, err := net.Listen("tcp", conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &IMDSServer{
+		IMDSConfig: conf,
+		listener:   listener,
+		creds:      &refresher{provider: p},
+		started:    time.Now(),
+		tokens:     map[string]time.Time{},
+	}
+	s.server = &http.Server{
+		Handler:   s,
+		ConnState: s.trackConn,
+	}
+
+	return s, nil
+}
+
+// URL is the endpoint to configure SDKs with, eg via
+// AWS_EC2_METADATA_SERVICE_ENDPOINT
+func (s *IMDSServer) URL() string {
+	return fmt.Sprintf("http://%s", s.listener.Addr().String())
+}
+
+// Seed serves creds, which the provider has already retrieved, until they
+// expire, so that the first request doesn't retrieve them again
+func (s *IMDSServer) Seed(creds credentials.Value) {
+	s.creds.seed(creds)
+}
+
+// Serve blocks answering requests until Close is called
+func (s *IMDSServer) Serve() error {
+	log.Debugf("Serving instance metadata on %s", s.URL())
+	if err := s.server.Serve(s.listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *IMDSServer) Close() error {
+	return s.server.Close()
+}
+
+func (s *IMDSServer) trackConn(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.conns.Store(c.RemoteAddr().String(), c)
+	case http.StateClosed, http.StateHijacked:
+		s.conns.Delete(c.RemoteAddr().String())
+	}
+}
+
+func (s *IMDSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// a web page rebinding its own host name to us can't read credentials,
+	// as its requests carry that name
+	if !imdsHostAllowed(r.Host) {
+		log.Debugf("rejected instance metadata request from %s for host %q", r.RemoteAddr, r.Host)
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path == "/latest/api/token" {
+		s.serveToken(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorized(r) {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/latest/meta-data/iam/security-credentials" || path == imdsCredentialsPath:
+		writeText(w, s.RoleName)
+	case strings.HasPrefix(path, imdsCredentialsPath):
+		if strings.TrimPrefix(path, imdsCredentialsPath) != s.RoleName {
+			http.NotFound(w, r)
+			return
+		}
+		s.serveCredentials(w)
+	case path == "/latest/meta-data/iam/info":
+		writeJSON(w, http.StatusOK, imdsInfo{
+			Code:               "Success",
+			LastUpdated:        s.started.UTC().Format(time.RFC3339),
+			InstanceProfileArn: fmt.Sprintf("arn:%s:iam::%s:instance-profile/%s", s.partition(), s.AccountID, s.RoleName),
+			InstanceProfileId:  "AIPAAWSOKTAEMULATED",
+		})
+	case path == "/latest/meta-data/placement/region":
+		writeText(w, s.Region)
+	case path == "/latest/meta-data/placement/availability-zone":
+		writeText(w, s.availabilityZone())
+	case path == "/latest/meta-data/instance-id":
+		writeText(w, "i-00000000000000000")
+	case path == "/latest/dynamic/instance-identity/document":
+		writeJSON(w, http.StatusOK, imdsIdentityDocument{
+			AccountID:        s.AccountID,
+			Architecture:     "x86_64",
+			AvailabilityZone: s.availabilityZone(),
+			ImageID:          "ami-00000000000000000",
+			InstanceID:       "i-00000000000000000",
+			InstanceType:     "t3.micro",
+			PendingTime:      s.started.UTC().Format(time.RFC3339),
+			PrivateIP:        "127.0.0.1",
+			Region:           s.Region,
+			Version:          "2017-09-30",
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveToken issues an IMDSv2 session token
+func (s *IMDSServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// like EC2, refuse token requests that went through a proxy
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+
+	ttlSeconds, err := strconv.Atoi(r.Header.Get(imdsTokenTTLHeader))
+	ttl := time.Duration(ttlSeconds) * time.Second
+	if err != nil || ttl < time.Second || ttl > imdsMaxTokenTTL {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	s.tokensMu.Lock()
+	now := time.Now()
+	for t, expires := range s.tokens {
+		if expires.Before(now) {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[token] = now.Add(ttl)
+	s.tokensMu.Unlock()
+
+	if s.HopLimit > 0 {
+		if err := s.limitHops(r.RemoteAddr); err != nil {
+			log.Debugf("failed setting hop limit for %s: %s", r.RemoteAddr, err)
+		}
+	}
+
+	w.Header().Set(imdsTokenTTLHeader, strconv.Itoa(ttlSeconds))
+	writeText(w, token)
+}
+
+// imdsHostAllowed returns whether host, from a request's Host header, is an IP
+// address, such as the listen address, a loopback address or EC2's, or
+// localhost. Other names could have been rebound to us by anyone.
+func imdsHostAllowed(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.EqualFold(host, "localhost") || net.ParseIP(host) != nil
+}
+
+// authorized checks the IMDSv2 token, if any. Tokenless requests are allowed
+// unless RequireToken is set.
+func (s *IMDSServer) authorized(r *http.Request) bool {
+	token := r.Header.Get(imdsTokenHeader)
+	if token == "" {
+		return !s.RequireToken
+	}
+
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
+	expires, ok := s.tokens[token]
+	return ok && time.Now().Before(expires)
+}
+
+func (s *IMDSServer) serveCredentials(w http.ResponseWriter) {
+	creds, expiration, err := s.creds.Get()
+	if err != nil {
+		log.Errorf("Failed to retrieve credentials: %s", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, imdsCredentials{
+		Code:            "Success",
+		LastUpdated:     time.Now().UTC().Format(time.RFC3339),
+		Type:            "AWS-HMAC",
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *IMDSServer) partition() string {
+	if s.Partition == "" {
+		return "aws"
+	}
+	return s.Partition
+}
+
+func (s *IMDSServer) availabilityZone() string {
+	if s.Region == "" {
+		return ""
+	}
+	return s.Region + "a"
+}
+
+// limitHops sets the IP TTL of the connection the request came in on
+func (s *IMDSServer) limitHops(remoteAddr string) error {
+	c, ok := s.conns.Load(remoteAddr)
+	if !ok {
+		return fmt.Errorf("connection not found")
+	}
+	tcpConn, ok := c.(*net.TCPConn)
+	if !ok {
+		return fmt.Errorf("not a TCP connection")
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	ipv6 := false
+	if addr, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok {
+		ipv6 = addr.IP.To4() == nil
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = setHopLimit(fd, ipv6, s.HopLimit)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func writeText(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, body)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIMDSServer(t *testing.T) {
+	p := &fakeProvider{expires: time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s, err := NewIMDSServer(p, IMDSConfig{
+		Addr:      "127.0.0.1:0",
+		RoleName:  "okta-role",
+		AccountID: "123456789012",
+		Region:    "us-west-2",
+	})
+	if err != nil {
+		t.Fatalf("error creating server: %s", err)
+	}
+	defer s.Close()
+
+	do := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, s.URL()+path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v[0])
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("token requires a ttl", func(t *testing.T) {
+		rec := do("PUT", "/latest/api/token", nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("token must be requested with PUT", func(t *testing.T) {
+		rec := do("GET", "/latest/api/token", http.Header{imdsTokenTTLHeader: {"60"}})
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+
+	t.Run("tokenless requests are allowed by default", func(t *testing.T) {
+		rec := do("GET", imdsCredentialsPath, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "okta-role", rec.Body.String())
+	})
+
+	t.Run("invalid token is rejected", func(t *testing.T) {
+		rec := do("GET", imdsCredentialsPath, http.Header{imdsTokenHeader: {"bogus"}})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("credentials with a session token", func(t *testing.T) {
+		rec := do("PUT", "/latest/api/token", http.Header{imdsTokenTTLHeader: {"60"}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "60", rec.Header().Get(imdsTokenTTLHeader))
+		token := rec.Body.String()
+
+		rec = do("GET", imdsCredentialsPath+"okta-role", http.Header{imdsTokenHeader: {token}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var got imdsCredentials
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("error decoding response: %s", err)
+		}
+		assert.Equal(t, "Success", got.Code)
+		assert.Equal(t, "AKID1", got.AccessKeyID)
+		assert.Equal(t, "3000-01-01T00:00:00Z", got.Expiration)
+	})
+
+	t.Run("foreign host is rejected", func(t *testing.T) {
+		for _, host := range []string{"attacker.example.com", "attacker.example.com:9911", "127.0.0.1.nip.io"} {
+			req := httptest.NewRequest("GET", s.URL()+imdsCredentialsPath+"okta-role", nil)
+			req.Host = host
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusForbidden, rec.Code, host)
+		}
+	})
+
+	t.Run("loopback hosts are allowed", func(t *testing.T) {
+		for _, host := range []string{"localhost:9911", "[::1]:9911", "169.254.169.254"} {
+			req := httptest.NewRequest("GET", s.URL()+imdsCredentialsPath, nil)
+			req.Host = host
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusOK, rec.Code, host)
+		}
+	})
+
+	t.Run("unknown role is not found", func(t *testing.T) {
+		rec := do("GET", imdsCredentialsPath+"other-role", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("identity document", func(t *testing.T) {
+		rec := do("GET", "/latest/dynamic/instance-identity/document", nil)
+
+		var got imdsIdentityDocument
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("error decoding response: %s", err)
+		}
+		assert.Equal(t, "123456789012", got.AccountID)
+		assert.Equal(t, "us-west-2", got.Region)
+	})
+
+	t.Run("tokenless requests are rejected when tokens are required", func(t *testing.T) {
+		s.RequireToken = true
+		defer func() { s.RequireToken = false }()
+
+		rec := do("GET", imdsCredentialsPath, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
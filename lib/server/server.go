@@ -0,0 +1,75 @@
+// server implements local HTTP endpoints that vend AWS credentials to child
+// processes, so they can pick up refreshed credentials instead of relying on
+// static keys injected into their environment.
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	log "github.com/sirupsen/logrus"
+)
+
+// Provider is the subset of lib.Provider used by the servers
+type Provider interface {
+	Retrieve() (credentials.Value, error)
+	IsExpired() bool
+	GetExpiration() time.Time
+}
+
+// refresher serializes access to a Provider and only calls Retrieve when the
+// credentials it last handed out are expired (or within the expiry window)
+type refresher struct {
+	mu       sync.Mutex
+	provider Provider
+	creds    credentials.Value
+	fetched  bool
+}
+
+// seed hands out creds, already retrieved from the provider, until they
+// expire
+func (r *refresher) seed(creds credentials.Value) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.creds = creds
+	r.fetched = true
+}
+
+func (r *refresher) Get() (credentials.Value, time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.fetched || r.provider.IsExpired() {
+		log.Debug("credentials missing or about to expire, retrieving")
+		creds, err := r.provider.Retrieve()
+		if err != nil {
+			return credentials.Value{}, time.Time{}, err
+		}
+		r.creds = creds
+		r.fetched = true
+	}
+
+	return r.creds, r.provider.GetExpiration(), nil
+}
+
+// randomToken returns a hex encoded random string of n bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugf("failed writing response: %s", err)
+	}
+}
@@ -9,6 +9,10 @@ type OktaUser struct {
 type OktaStateToken struct {
 	StateToken string `json:"stateToken"`
 	PassCode   string `json:"passCode"`
+	// Answer is the answer to a security question factor
+	Answer string `json:"answer,omitempty"`
+	// NextPassCode is the next tokencode, when RSA SecurID asks for it
+	NextPassCode string `json:"nextPassCode,omitempty"`
 }
 
 type OktaUserAuthn struct {
@@ -18,11 +22,41 @@ type OktaUserAuthn struct {
 	Status       string                `json:"status"`
 	Embedded     OktaUserAuthnEmbedded `json:"_embedded"`
 	FactorResult string                `json:"factorResult"`
+	// FactorResultMessage says what a factor needs to go on, eg the next
+	// tokencode or a new PIN for RSA SecurID
+	FactorResultMessage string `json:"factorResultMessage"`
 }
 
 type OktaUserAuthnEmbedded struct {
 	Factors []OktaUserAuthnFactor `json:"factors"`
 	Factor  OktaUserAuthnFactor   `json:"factor"`
+	Policy  OktaUserAuthnPolicy   `json:"policy"`
+}
+
+// OktaUserAuthnPolicy is the password policy sent with PASSWORD_WARN and
+// PASSWORD_EXPIRED
+type OktaUserAuthnPolicy struct {
+	Expiration OktaUserAuthnPolicyExpiration `json:"expiration"`
+	Complexity OktaUserAuthnPolicyComplexity `json:"complexity"`
+}
+
+type OktaUserAuthnPolicyExpiration struct {
+	PasswordExpireDays int `json:"passwordExpireDays"`
+}
+
+type OktaUserAuthnPolicyComplexity struct {
+	MinLength       int  `json:"minLength"`
+	MinLowerCase    int  `json:"minLowerCase"`
+	MinUpperCase    int  `json:"minUpperCase"`
+	MinNumber       int  `json:"minNumber"`
+	MinSymbol       int  `json:"minSymbol"`
+	ExcludeUsername bool `json:"excludeUsername"`
+}
+
+type OktaChangePassword struct {
+	StateToken  string `json:"stateToken"`
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
 }
 
 type OktaUserAuthnFactor struct {
@@ -37,6 +71,12 @@ type OktaUserAuthnFactorProfile struct {
 	CredentialId string `json:"credentialId"`
 	AppId        string `json:"appId"`
 	Version      string `json:"version"`
+	PhoneNumber  string `json:"phoneNumber"`
+	Email        string `json:"email"`
+	QuestionText string `json:"questionText"`
+	// Name is the name of the device running Okta Verify
+	Name              string `json:"name"`
+	AuthenticatorName string `json:"authenticatorName"`
 }
 
 type OktaUserAuthnFactorEmbedded struct {
@@ -55,6 +95,9 @@ type OktaUserAuthnFactorEmbeddedChallenge struct {
 	Nonce           string `json:"nonce"`
 	Challenge       string `json:"challenge"`
 	TimeoutSeconnds int    `json:"timeoutSeconds"`
+	// CorrectAnswer is the number to choose in Okta Verify when number
+	// matching is on
+	CorrectAnswer int `json:"correctAnswer"`
 }
 type OktaUserAuthnFactorEmbeddedVerificationLinks struct {
 	Complete OktaUserAuthnFactorEmbeddedVerificationLinksComplete `json:"complete"`
@@ -63,3 +106,14 @@ type OktaUserAuthnFactorEmbeddedVerificationLinks struct {
 type OktaUserAuthnFactorEmbeddedVerificationLinksComplete struct {
 	Href string `json:"href"`
 }
+
+// OktaError is the body of an okta API error response
+type OktaError struct {
+	ErrorCode    string           `json:"errorCode"`
+	ErrorSummary string           `json:"errorSummary"`
+	ErrorCauses  []OktaErrorCause `json:"errorCauses"`
+}
+
+type OktaErrorCause struct {
+	ErrorSummary string `json:"errorSummary"`
+}
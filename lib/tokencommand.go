@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// TokenCommandTimeout is how long mfa_token_command may run
+var TokenCommandTimeout = 30 * time.Second
+
+// how many passcodes from mfa_token_command okta may reject before the user
+// is prompted instead
+const maxTokenCommandAttempts = 2
+
+// okta's error code for a wrong passcode or answer
+const oktaErrInvalidPasscode = "E0000068"
+
+// runTokenCommand runs command with the shell and returns the passcode it
+// prints. Its stderr is passed through, eg for "touch your YubiKey".
+func runTokenCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), TokenCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	log.Debugf("Running mfa_token_command: %s", command)
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("mfa_token_command failed: %s", err)
+	}
+	// Wait doesn't return until the command's children close its stdout,
+	// even once it's been killed, so don't wait for it after the timeout
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return "", fmt.Errorf("mfa_token_command timed out after %s", TokenCommandTimeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("mfa_token_command failed: %s", err)
+	}
+
+	passcode := strings.TrimSpace(stdout.String())
+	if passcode == "" {
+		return "", errors.New("mfa_token_command printed no passcode")
+	}
+	if strings.ContainsAny(passcode, " \t\r\n") {
+		return "", errors.New("mfa_token_command printed more than a passcode")
+	}
+	return passcode, nil
+}
+
+// tokenCode gets a passcode for a token factor from mfa_token_command if
+// it's set and hasn't failed, and from the user otherwise
+func (o *OktaClient) tokenCode(promptText string, sensitive bool) (string, error) {
+	o.passcodeFromCommand = false
+	if o.MFAConfig.TokenCommand != "" && o.tokenCommandAttempts < maxTokenCommandAttempts {
+		o.tokenCommandAttempts++
+		passcode, err := runTokenCommand(o.MFAConfig.TokenCommand)
+		if err == nil {
+			o.passcodeFromCommand = true
+			return passcode, nil
+		}
+		// don't run a broken command again
+		o.tokenCommandAttempts = maxTokenCommandAttempts
+		if o.NonInteractive {
+			return "", &InteractionRequiredError{Reason: err.Error()}
+		}
+		log.Warnf("%s; enter the passcode instead", err)
+	}
+	return prompt(promptText, sensitive)
+}
+
+// retryTokenCommand returns whether verifying a passcode from
+// mfa_token_command failed because okta rejected it, so that another can be
+// tried
+func (o *OktaClient) retryTokenCommand(err error) bool {
+	var httpErr *HTTPError
+	if !o.passcodeFromCommand || !xerrors.As(err, &httpErr) || httpErr.Code != oktaErrInvalidPasscode {
+		return false
+	}
+	if o.tokenCommandAttempts < maxTokenCommandAttempts {
+		log.Warn("Okta rejected the passcode from mfa_token_command; running it again")
+	} else if o.NonInteractive {
+		return false
+	} else {
+		log.Warn("Okta rejected the passcode from mfa_token_command; enter the passcode instead")
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestRunTokenCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	defer func(timeout time.Duration) { TokenCommandTimeout = timeout }(TokenCommandTimeout)
+	TokenCommandTimeout = 200 * time.Millisecond
+
+	passcode, err := runTokenCommand("echo ' 123456 '")
+	if err != nil || passcode != "123456" {
+		t.Errorf("expected the printed passcode, got %q, %v", passcode, err)
+	}
+	for _, command := range []string{"exit 1", "true", "printf '1\\n2\\n'", "sleep 1"} {
+		if _, err := runTokenCommand(command); err == nil {
+			t.Errorf("%s: expected an error", command)
+		}
+	}
+}
+
+func totpRequired() OktaUserAuthn {
+	return mfaRequired(OktaUserAuthnFactor{Id: "totp1", Provider: "GOOGLE", FactorType: "token:software:totp"})
+}
+
+func TestAuthenticateTokenCommandRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	invalid := OktaError{ErrorCode: oktaErrInvalidPasscode, ErrorSummary: "Invalid Passcode/Answer"}
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", totpRequired())
+	stub.on("/api/v1/authn/factors/totp1/verify", invalid, invalid, OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"})
+	prompts, restore := stubPrompt(t, "654321")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+	o.MFAConfig.TokenCommand = "echo 111111"
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	verifies := stub.requests["/api/v1/authn/factors/totp1/verify"]
+	if len(verifies) != 3 || verifies[0]["passCode"] != "111111" || verifies[1]["passCode"] != "111111" || verifies[2]["passCode"] != "654321" {
+		t.Errorf("expected two passcodes from the command, then one from the user; got %v", verifies)
+	}
+	if len(*prompts) != 1 {
+		t.Errorf("expected one prompt, got %q", *prompts)
+	}
+}
+
+func TestAuthenticateTokenCommandFallback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", totpRequired())
+	stub.on("/api/v1/authn/factors/totp1/verify", OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"})
+	_, restore := stubPrompt(t, "654321")
+	defer restore()
+	o, done := stub.client()
+	defer done()
+	o.MFAConfig.TokenCommand = "exit 3"
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatal(err)
+	}
+	if verifies := stub.requests["/api/v1/authn/factors/totp1/verify"]; len(verifies) != 1 || verifies[0]["passCode"] != "654321" {
+		t.Errorf("expected the passcode from the user, got %v", verifies)
+	}
+}
+
+func TestAuthenticateTokenCommandNonInteractive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	stub := newOktaStub(t)
+	stub.on("/api/v1/authn", totpRequired())
+	stub.on("/api/v1/authn/factors/totp1/verify", OktaUserAuthn{Status: AuthnStatusSuccess, SessionToken: "session"})
+	o, done := stub.client()
+	defer done()
+	o.NonInteractive = true
+	o.MFAConfig.TokenCommand = "echo 111111"
+
+	if err := o.AuthenticateUser(); err != nil {
+		t.Fatalf("expected MFA to be done without the user, got %v", err)
+	}
+
+	stub.on("/api/v1/authn", mfaRequired(OktaUserAuthnFactor{Id: "push1", Provider: "OKTA", FactorType: "push"}))
+	stub.responses["/api/v1/authn"] = stub.responses["/api/v1/authn"][1:]
+	var interactionErr *InteractionRequiredError
+	if err := o.AuthenticateUser(); !xerrors.As(err, &interactionErr) {
+		t.Errorf("expected a push to need the user, got %v", err)
+	}
+}
@@ -7,6 +7,7 @@ package sessioncache
 import (
 	"encoding/json"
 	"errors"
+	"regexp"
 
 	"github.com/aws/aws-sdk-go/service/sts"
 )
@@ -14,6 +15,8 @@ import (
 // Session adds a session name to sts.Credentials
 type Session struct {
 	Name string
+	// RoleARN is the role assumed with SAML, when it wasn't configured
+	RoleARN string `json:",omitempty"`
 	sts.Credentials
 }
 
@@ -27,3 +30,17 @@ type Key interface {
 }
 
 var ErrSessionExpired = errors.New("session expired")
+
+// keys made by OrigKey and KeyWithProfileARN look like
+// "<source profile> session (<hash>)"
+var sessionKeyRegex = regexp.MustCompile(`^(.*) session \([0-9a-f]+\)$`)
+
+// KeyProfile returns the source profile a session key is for, or false if key
+// isn't a session key
+func KeyProfile(key string) (string, bool) {
+	m := sessionKeyRegex.FindStringSubmatch(key)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
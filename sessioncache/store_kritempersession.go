@@ -71,3 +71,26 @@ func (s *KrItemPerSessionStore) Put(k Key, session *Session) error {
 
 	return nil
 }
+
+// Clear removes the sessions of the source profiles for which match returns
+// true, and returns how many it removed
+func (s *KrItemPerSessionStore) Clear(match func(profile string) bool) (int, error) {
+	keys, err := s.Keyring.Keys()
+	if err != nil {
+		return 0, xerrors.Errorf("listing keyring: %w", err)
+	}
+
+	removed := 0
+	for _, key := range keys {
+		profile, ok := KeyProfile(key)
+		if !ok || !match(profile) {
+			continue
+		}
+		if err := s.Keyring.Remove(key); err != nil {
+			return removed, xerrors.Errorf("removing %q: %w", key, err)
+		}
+		log.Debugf("cache clear `%s`: removed", key)
+		removed++
+	}
+	return removed, nil
+}
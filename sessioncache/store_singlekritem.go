@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/99designs/keyring"
+	"github.com/segmentio/aws-okta/internal/filelock"
 	log "github.com/sirupsen/logrus"
 
 	// use xerrors until 1.13 is stable/oldest supported version
@@ -15,6 +16,9 @@ import (
 const KeyringItemKey = "session-cache"
 const KeyringItemLabel = "aws-okta session cache"
 
+// how long Put waits for other processes writing the db
+const lockTimeout = 30 * time.Second
+
 type singleKrItemDb struct {
 	Sessions map[string]Session
 }
@@ -76,9 +80,24 @@ func (s *SingleKrItemStore) Get(k Key) (*Session, error) {
 	return &session, nil
 }
 
+// Put adds the session to the db at k.Key()
+//
+// The db is read, updated and written back while holding a lock shared with
+// other aws-okta processes, so that concurrent Puts don't lose each other's
+// sessions
 func (s *SingleKrItemStore) Put(k Key, session *Session) error {
 	keyStr := k.Key()
 
+	lock, err := filelock.Acquire(KeyringItemKey, lockTimeout)
+	if xerrors.Is(err, filelock.ErrTimeout) {
+		return xerrors.Errorf("locking db for %q: %w", keyStr, err)
+	} else if err != nil {
+		// eg on a filesystem without lock support
+		log.Debugf("cache put `%s`: continuing without lock: %s", keyStr, err)
+	} else {
+		defer lock.Release()
+	}
+
 	currentDb, err := s.getDb()
 	if xerrors.Is(err, keyring.ErrKeyNotFound) || (currentDb != nil && currentDb.Sessions == nil) {
 		log.Debugf("cache put: new db")
@@ -98,10 +117,6 @@ func (s *SingleKrItemStore) Put(k Key, session *Session) error {
 		return xerrors.Errorf("marshalling db for %q: %w", keyStr, err)
 	}
 
-	// TODO: check that the db hasn't changed behind our backs
-	// Unfortunately, it seems like keyring (and MacOS keychain in general)
-	// offer no "check and set" operation that would guarantee this would work;
-	// at best, we could only make the window smaller :/
 	item := keyring.Item{
 		Key:                         KeyringItemKey,
 		Label:                       KeyringItemLabel,
@@ -116,3 +131,51 @@ func (s *SingleKrItemStore) Put(k Key, session *Session) error {
 
 	return nil
 }
+
+// Clear removes the sessions of the source profiles for which match returns
+// true from the db, and returns how many it removed
+func (s *SingleKrItemStore) Clear(match func(profile string) bool) (int, error) {
+	lock, err := filelock.Acquire(KeyringItemKey, lockTimeout)
+	if xerrors.Is(err, filelock.ErrTimeout) {
+		return 0, xerrors.Errorf("locking db: %w", err)
+	} else if err != nil {
+		log.Debugf("cache clear: continuing without lock: %s", err)
+	} else {
+		defer lock.Release()
+	}
+
+	currentDb, err := s.getDb()
+	if xerrors.Is(err, keyring.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, xerrors.Errorf("loading db: %w", err)
+	}
+
+	removed := 0
+	for key := range currentDb.Sessions {
+		if profile, ok := KeyProfile(key); ok && match(profile) {
+			delete(currentDb.Sessions, key)
+			log.Debugf("cache clear `%s`: removed", key)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	bytes, err := json.Marshal(*currentDb)
+	if err != nil {
+		return 0, xerrors.Errorf("marshalling db: %w", err)
+	}
+
+	item := keyring.Item{
+		Key:                         KeyringItemKey,
+		Label:                       KeyringItemLabel,
+		Data:                        bytes,
+		KeychainNotTrustApplication: false,
+	}
+	if err := s.Keyring.Set(item); err != nil {
+		return 0, xerrors.Errorf("writing db: %w", err)
+	}
+	return removed, nil
+}
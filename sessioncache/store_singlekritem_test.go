@@ -1,12 +1,24 @@
 package sessioncache
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/99designs/keyring"
+	"github.com/segmentio/aws-okta/internal/filelock"
 )
 
 func TestSingleKrItemStore(t *testing.T) {
+	// keep the db's lock out of the real ~/.aws-okta/locks
+	dir, err := ioutil.TempDir("", "sessioncache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filelock.Directory = dir
+	defer func() { filelock.Directory = "" }()
+
 	testStore(t, func() store {
 		return &SingleKrItemStore{
 			Keyring: keyring.NewArrayKeyring([]keyring.Item{}),
@@ -9,10 +9,11 @@ import (
 	"golang.org/x/xerrors"
 )
 
-// duplicates lib.SessionCacheInterface
+// duplicates lib.SessionCacheInterface, plus Clear
 type store interface {
 	Get(Key) (*Session, error)
 	Put(Key, *Session) error
+	Clear(func(profile string) bool) (int, error)
 }
 
 var theDistantFuture = time.Date(3000, 0, 0, 0, 0, 0, 0, time.UTC)
@@ -73,4 +74,36 @@ func testStore(t *testing.T, storeFactory func() store) {
 			t.Fatalf("expected get err to be ErrSessionExpired; is %s", err)
 		}
 	})
+
+	tName = "clear removes matching profiles' sessions"
+	t.Run(tName, func(t *testing.T) {
+		st := storeFactory()
+		sess := Session{
+			Name: tName,
+			Credentials: sts.Credentials{
+				// avoid expiration
+				Expiration: &theDistantFuture,
+			},
+		}
+		dev := KeyWithProfileARN{ProfileName: "dev", ProfileConf: map[string]string{}}
+		prod := KeyWithProfileARN{ProfileName: "prod", ProfileConf: map[string]string{}}
+		for _, key := range []Key{dev, prod} {
+			if err := st.Put(key, &sess); err != nil {
+				t.Fatalf("error on put: %s", err)
+			}
+		}
+
+		removed, err := st.Clear(func(profile string) bool { return profile == "dev" })
+		if err != nil {
+			t.Fatalf("error on clear: %s", err)
+		}
+		assert.Equal(t, 1, removed)
+
+		if _, err := st.Get(dev); err == nil {
+			t.Errorf("expected dev's session to be cleared")
+		}
+		if _, err := st.Get(prod); err != nil {
+			t.Errorf("expected prod's session to be kept: %s", err)
+		}
+	})
 }